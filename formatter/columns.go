@@ -0,0 +1,160 @@
+package tabulon
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// splitColumnSpec splits a column specification on commas, except within a
+// /regex/ item.
+func splitColumnSpec(spec string) []string {
+	var items []string
+	var item []byte
+	in_regex := false
+	for i:=0; i<len(spec); i++ {
+		c := spec[i]
+		if c=='/' && (len(item)==0 || (len(item)==1 && item[0]=='!')) {
+			in_regex = true
+		} else if c=='/' && in_regex {
+			in_regex = false
+		}
+
+		if c==',' && !in_regex {
+			items = append(items, string(item))
+			item = item[:0]
+			continue
+		}
+		item = append(item, c)
+	}
+	return append(items, string(item))
+}
+
+// matchColumns returns the indices of the header columns selected by one
+// item: an exact name, a 1-based index or range such as 2-4 or 3-, or a
+// /regex/ on the names.
+func matchColumns(item string, header []string) ([]int, error) {
+	if idx := findToken(item, header); idx!=-1 {
+		return []int{idx}, nil
+	}
+
+	if len(item)>=2 && strings.HasPrefix(item, "/") && strings.HasSuffix(item, "/") {
+		re, err := regexp.Compile(item[1:len(item)-1])
+		if err != nil {
+			return nil, errors.New("invalid column pattern: " + item)
+		}
+
+		var out []int
+		for i,col := range(header) {
+			if re.MatchString(col) {
+				out = append(out, i)
+			}
+		}
+		return out, nil
+	}
+
+	bounds := strings.SplitN(item, "-", 2)
+	lo, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, errors.New("specified column not found: " + item)
+	}
+	hi := lo
+	if len(bounds)==2 && len(bounds[1])==0 {
+		hi = len(header)
+	} else if len(bounds)==2 {
+		if hi, err = strconv.Atoi(bounds[1]); err != nil {
+			return nil, errors.New("specified column not found: " + item)
+		}
+	}
+	if lo<1 || hi>len(header) || lo>hi {
+		return nil, errors.New("column index out of range: " + item)
+	}
+
+	var out []int
+	for i:=lo; i<=hi; i++ {
+		out = append(out, i-1)
+	}
+	return out, nil
+}
+
+// resolveColumns turns column specifications into header indices, in the
+// order given. Each specification is a comma separated list of items as
+// understood by matchColumns, "*" for every column not otherwise selected,
+// or "!item" to exclude columns. A specification that is exactly the name
+// of a column is not split. A list of only exclusions starts from all
+// columns.
+func resolveColumns(specs []string, header []string) ([]int, error) {
+	var items []string
+	for _,spec := range(specs) {
+		// a column name containing commas is taken as a whole
+		name := strings.TrimPrefix(spec, "!")
+		if strings.Contains(name, ",") && findToken(name, header)!=-1 {
+			items = append(items, spec)
+		} else {
+			items = append(items, splitColumnSpec(spec)...)
+		}
+	}
+
+	excluded := make(map[int]bool)
+	selected := make(map[int]bool)
+	has_positive := false
+	for _,item := range(items) {
+		if strings.HasPrefix(item, "!") {
+			idx, err := matchColumns(item[1:], header)
+			if err != nil {
+				return nil, err
+			}
+			for _,i := range(idx) {
+				excluded[i] = true
+			}
+			continue
+		}
+
+		has_positive = true
+		if item=="*" {
+			continue
+		}
+		idx, err := matchColumns(item, header)
+		if err != nil {
+			return nil, err
+		}
+		for _,i := range(idx) {
+			selected[i] = true
+		}
+	}
+
+	if !has_positive {
+		items = append([]string{"*"}, items...)
+	}
+
+	var out []int
+	seen := make(map[int]bool)
+	add := func(i int) {
+		if !seen[i] && !excluded[i] {
+			seen[i] = true
+			out = append(out, i)
+		}
+	}
+
+	for _,item := range(items) {
+		if strings.HasPrefix(item, "!") {
+			continue
+		}
+
+		if item=="*" {
+			for i := range(header) {
+				if !selected[i] {
+					add(i)
+				}
+			}
+			continue
+		}
+
+		idx, _ := matchColumns(item, header)
+		for _,i := range(idx) {
+			add(i)
+		}
+	}
+	return out, nil
+}
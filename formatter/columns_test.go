@@ -0,0 +1,79 @@
+package tabulon
+
+import (
+	"testing"
+	"reflect"
+)
+
+func Test_resolveColumns(t *testing.T) {
+	header := []string{"id", "name", "price_net", "price_gross", "internal_id"}
+	cases := []struct {
+		specs []string
+		expected []int
+	}{
+		{[]string{"name", "id"}, []int{1, 0}},
+		{[]string{"1-3,5"}, []int{0, 1, 2, 4}},
+		{[]string{"4-"}, []int{3, 4}},
+		{[]string{"/^price_/"}, []int{2, 3}},
+		{[]string{"/^(id|name)$/,5"}, []int{0, 1, 4}},
+		{[]string{"!internal_id"}, []int{0, 1, 2, 3}},
+		{[]string{"!/price/", "!1"}, []int{1, 4}},
+		{[]string{"internal_id,*"}, []int{4, 0, 1, 2, 3}},
+		{[]string{"*,id", "!/price/"}, []int{1, 4, 0}},
+		{[]string{"id", "1"}, []int{0}},
+	}
+
+	for _,c := range(cases) {
+		idx, err := resolveColumns(c.specs, header)
+		if err != nil {
+			t.Errorf("%v: %v", c.specs, err)
+		} else if !reflect.DeepEqual(idx, c.expected) {
+			t.Errorf("%v: got %v, expected %v", c.specs, idx, c.expected)
+		}
+	}
+
+	for _,spec := range([]string{"missing", "0", "6", "3-2", "/(/"}) {
+		if _, err := resolveColumns([]string{spec}, header); err == nil {
+			t.Errorf("%s should not resolve", spec)
+		}
+	}
+}
+
+func Test_numericColumnName(t *testing.T) {
+	idx, err := resolveColumns([]string{"2020"}, []string{"region", "2019", "2020"})
+	if err != nil || !reflect.DeepEqual(idx, []int{2}) {
+		t.Errorf("name should take precedence over index, got %v %v", idx, err)
+	}
+}
+
+func Test_readerColumns(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetColumns([]string{"3,!2,*"})
+	reader.ParseLine("a,b,c,d")
+	if !reflect.DeepEqual(reader.GetHeader(), []string{"c", "a", "d"}) {
+		t.Errorf("incorrect header %v", reader.GetHeader())
+	}
+
+	row := reader.ParseLine("1,2,3,4")
+	if !reflect.DeepEqual(row, []string{"3", "1", "4"}) {
+		t.Errorf("incorrect row %v", row)
+	}
+}
+
+func Test_columnNameWithComma(t *testing.T) {
+	header := []string{"id", "Last, First", "age"}
+	idx, err := resolveColumns([]string{"Last, First", "id"}, header)
+	if err != nil || !reflect.DeepEqual(idx, []int{1, 0}) {
+		t.Errorf("got %v %v", idx, err)
+	}
+
+	idx, err = resolveColumns([]string{"!Last, First"}, header)
+	if err != nil || !reflect.DeepEqual(idx, []int{0, 2}) {
+		t.Errorf("got %v %v", idx, err)
+	}
+
+	idx, err = resolveColumns([]string{"age,id"}, header)
+	if err != nil || !reflect.DeepEqual(idx, []int{2, 0}) {
+		t.Errorf("got %v %v", idx, err)
+	}
+}
@@ -0,0 +1,62 @@
+package tabulon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+)
+
+var compression_exts = []string{".gz", ".gzip", ".bz2", ".bzip2", ".zz", ".zlib"}
+
+var bzip2_block_magic = []byte{0x31, 0x41, 0x59, 0x26, 0x53, 0x59}
+var bzip2_eos_magic = []byte{0x17, 0x72, 0x45, 0x38, 0x50, 0x90}
+
+// Compression identifies the compression format of the input by its magic
+// bytes, or returns an empty string for uncompressed input.
+func Compression(br *bufio.Reader) (string) {
+	magic, _ := br.Peek(10)
+	if len(magic)>=2 && magic[0]==0x1f && magic[1]==0x8b {
+		return "gzip"
+	}
+
+	if len(magic)==10 && bytes.HasPrefix(magic, []byte("BZh")) && magic[3]>='1' && magic[3]<='9' {
+		if bytes.Equal(magic[4:], bzip2_block_magic) || bytes.Equal(magic[4:], bzip2_eos_magic) {
+			return "bzip2"
+		}
+	}
+
+	// zlib: deflate method in the low nibble and a header checksum; only the
+	// common levels are accepted so that text starting with 'x' is not taken
+	if len(magic)>=2 && magic[0]==0x78 && (magic[1]==0x01 || magic[1]==0x9c || magic[1]==0xda) {
+		return "zlib"
+	}
+
+	return ""
+}
+
+// decompress wraps the input in a decompressor if it is compressed.
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	switch Compression(br) {
+	case "gzip":
+		return gzip.NewReader(br)
+	case "bzip2":
+		return bzip2.NewReader(br), nil
+	case "zlib":
+		return zlib.NewReader(br)
+	}
+
+	return br, nil
+}
+
+func trimCompressionExt(fname string) (string) {
+	for _,ext := range(compression_exts) {
+		if strings.HasSuffix(fname, ext) {
+			return strings.TrimSuffix(fname, ext)
+		}
+	}
+	return fname
+}
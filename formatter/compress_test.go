@@ -0,0 +1,71 @@
+package tabulon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"testing"
+)
+
+// "a|b\n1|2\n" compressed with bzip2
+var bzip2_sample = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xa6, 0xdc,
+	0xa7, 0xea, 0x00, 0x00, 0x02, 0x49, 0x80, 0x00, 0x10, 0x30, 0x00, 0x30,
+	0x00, 0x00, 0x04, 0x20, 0x00, 0x21, 0x80, 0x0c, 0x02, 0xc7, 0x92, 0xbb,
+	0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48, 0x53, 0x6e, 0x53, 0xf5, 0x00,
+}
+
+func Test_decompress(t *testing.T) {
+	plain := "a|b\n1|2\n"
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(plain))
+	w.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(plain))
+	zw.Close()
+
+	inputs := map[string][]byte{
+		"gzip": gz.Bytes(),
+		"zlib": zl.Bytes(),
+		"bzip2": bzip2_sample,
+		"": []byte(plain),
+	}
+
+	table := NewTable()
+	for format, input := range(inputs) {
+		if c := Compression(bufio.NewReader(bytes.NewReader(input))); c != format {
+			t.Error("compression detected as ", c, ", expected ", format)
+		}
+
+		br, err := table.openInput(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(format, ": ", err)
+		}
+		out, _ := ioutil.ReadAll(br)
+		if string(out) != plain {
+			t.Error(format, ": decompressed content incorrect: ", string(out))
+		}
+	}
+
+	if c := Compression(bufio.NewReader(bytes.NewReader([]byte("x^y,z\n")))); c != "" {
+		t.Error("text starting with x should not be compressed: ", c)
+	}
+}
+
+func Test_guessDelimiterCompressed(t *testing.T) {
+	if guessDelimiter("foo.tsv.gz") != '\t' {
+		t.Error("inner tsv extension not used")
+	}
+	if guessDelimiter("/data/daily.PSV.bz2") != '|' {
+		t.Error("inner psv extension not used")
+	}
+	if guessDelimiter("foo.gz") != ',' {
+		t.Error("compressed file without inner extension should default to comma")
+	}
+}
@@ -1,22 +1,79 @@
 package tabulon
 
 import (
+	"fmt"
 	"strings"
-	"log"
+	"strconv"
+	"unicode/utf8"
 )
 
+// names of the synthetic columns holding the source of each row
+const (
+	filename_column = "_file"
+	lineno_column = "_line"
+)
+
+type RowReader interface {
+	ParseLine(line string) ([]string)
+	GetHeader() ([]string)
+	IsPending() (bool)
+	Flush() ([]string)
+	Err() (error)
+	Ragged() (*ParseError)
+	Warnings() ([]string)
+}
+
+// recordHook processes the records of a reader before the columns are
+// selected, so that -c can select derived columns and neither derived
+// columns nor filters are limited to the columns it keeps.
+type recordHook interface {
+	// prepareHeader returns the header with the derived columns appended.
+	prepareHeader(header []string) ([]string, error)
+	// prepareRecord returns the record with the derived cells appended, or
+	// nil if the filters reject it.
+	prepareRecord(header []string, row []string) ([]string, error)
+}
+
 type CSVReader struct {
 	header []string
 	ncols int
+	nfields int
 	delimiter rune
 	quote rune
+	escape rune
 	columns []string
 	column_map []int
 	limit int
+	no_header bool
+	names []string
+	record []string
+	token []byte
+	in_quote bool
+	cell_start bool
+	fname string
+	with_filename bool
+	with_lineno bool
+	comment string
+	skip_blank bool
+	renames map[string]string
+	normalize bool
+	hook recordHook
+	fields []string
+	reject_ragged bool
+	warnings []string
+	line int
+	record_line int
+	raw string
+	ragged *ParseError
+	err error
 }
 
 func NewCSVReader() (CSVReader) {
-	r := CSVReader{nil, 0, ',', '"', nil, nil, 0}
+	r := CSVReader{
+		delimiter: ',',
+		quote: '"',
+		cell_start: true,
+	}
 	return r
 }
 
@@ -24,20 +81,84 @@ func (c *CSVReader) SetDelimiter(d rune) {
 	c.delimiter = d
 }
 
+func (c *CSVReader) SetQuote(q rune) {
+	c.quote = q
+}
+
+func (c *CSVReader) SetEscape(e rune) {
+	c.escape = e
+}
+
 func (c *CSVReader) SetColumns(cols []string) {
 	c.columns = cols
 }
 
+func (c *CSVReader) SetNoHeader(no_header bool) {
+	c.no_header = no_header
+}
+
+// SetHeaderNames names the columns of headerless input; columns beyond the
+// supplied names get generated names.
+func (c *CSVReader) SetHeaderNames(names []string) {
+	c.names = names
+	if len(names)>0 {
+		c.no_header = true
+	}
+}
+
 func (c *CSVReader) SetLimit(limit int) {
 	c.limit = limit
 }
 
+func (c *CSVReader) SetFileName(fname string) {
+	c.fname = fname
+}
+
+// SetProvenance adds columns holding the file name and the line number at
+// which each record starts.
+func (c *CSVReader) SetProvenance(with_filename bool, with_lineno bool) {
+	c.with_filename = with_filename
+	c.with_lineno = with_lineno
+}
+
+// SetRecordHook has each record passed through the hook before the columns
+// are selected.
+func (c *CSVReader) SetRecordHook(hook recordHook) {
+	c.hook = hook
+}
+
+// SetRejectRagged leaves records with the wrong number of fields to be
+// rejected without passing them through the hook.
+func (c *CSVReader) SetRejectRagged(reject_ragged bool) {
+	c.reject_ragged = reject_ragged
+}
+
+// SetComment ignores lines starting with the comment prefix, except within
+// a quoted cell.
+func (c *CSVReader) SetComment(comment string) {
+	c.comment = comment
+}
+
+func (c *CSVReader) SetSkipBlank(skip_blank bool) {
+	c.skip_blank = skip_blank
+}
+
+// SetLineOffset accounts for lines consumed before the reader, so that
+// reported line numbers match the input.
+func (c *CSVReader) SetLineOffset(n int) {
+	c.line = n
+}
+
 func (c *CSVReader) GetHeader() ([]string) {
 	return c.header
 }
 
 func (c *CSVReader) Reset() {
 	c.header = nil
+	c.record = nil
+	c.token = c.token[:0]
+	c.in_quote = false
+	c.cell_start = true
 }
 
 func (r *CSVReader) appendToken(row []string, token string) ([]string) {
@@ -48,58 +169,63 @@ func (r *CSVReader) appendToken(row []string, token string) ([]string) {
 	return append(row, token)
 }
 
-func (r *CSVReader) tokenize(line string) ([]string) {
-	var row []string
-	var token string
-	i := 0
+func (r *CSVReader) endToken() {
+	r.record = r.appendToken(r.record, string(r.token))
+	r.token = r.token[:0]
+	r.cell_start = true
+}
+
+// tokenize consumes one physical line into the current record. It returns
+// false while a quoted cell is still open, in which case the record continues
+// on the next line. Inside a quoted cell a doubled quote is a literal quote;
+// if an escape character is set it makes the following character literal.
+func (r *CSVReader) tokenize(line string) (bool) {
+	if r.in_quote {
+		r.token = append(r.token, '\n')
+	}
+
 	N := len(line)
+	for i:=0; i<N; {
+		c, w := utf8.DecodeRuneInString(line[i:])
+		i += w
 
-	for {
-		// check and handle end of line
-		if i>= N {
-			break
+		if c==r.escape && r.escape!=0 && r.escape!=r.quote && i<N {
+			c, w = utf8.DecodeRuneInString(line[i:])
+			i += w
+			r.token = append(r.token, string(c)...)
+			r.cell_start = false
+			continue
 		}
 
-		// easy case; check if cell is not quoted
-		if line[i]!=byte(r.quote) {
-			if idx_end := strings.Index(line[i:], string(r.delimiter)); idx_end==-1 {
-				token = line[i:N]
-				row = r.appendToken(row, token)
-				i = N
+		if r.in_quote {
+			if c!=r.quote {
+				r.token = append(r.token, string(c)...)
+			} else if next, w := utf8.DecodeRuneInString(line[i:]); i<N && next==r.quote {
+				r.token = append(r.token, string(r.quote)...)
+				i += w
 			} else {
-				token = line[i:i+idx_end]
-				row = r.appendToken(row, token)
-				i += idx_end + 1
+				r.in_quote = false
 			}
+			continue
+		}
 
+		if c==r.delimiter {
+			r.endToken()
+		} else if c==r.quote && r.cell_start {
+			r.in_quote = true
+			r.cell_start = false
 		} else {
-			i++
-
-			// quoted cell, intermediate position
-			quoted_delim := string(r.quote) + string(r.delimiter)
-			if idx_end := strings.Index(line[i:], quoted_delim); idx_end!=-1 {
-				token = line[i:i+idx_end]
-				row = r.appendToken(row, token)
-				i += idx_end + 2
-
-				// quoted cell, final position
-			} else {
-				if idx_end2 := strings.Index(line[i:], string(r.quote)); idx_end2!=-1 {
-					token = line[i:i+idx_end2]
-					row = r.appendToken(row, token)
-					i += idx_end2 + 1
-
-					// quoted cell, unterminated quote
-				} else {
-					token = line[i:N]
-					row = r.appendToken(row, token)
-					i = N
-				}
-			}
+			r.token = append(r.token, string(c)...)
+			r.cell_start = false
 		}
 	}
 
-	return row
+	if r.in_quote {
+		return false
+	}
+
+	r.endToken()
+	return true
 }
 
 func (r *CSVReader) normalizeRow(row []string) ([]string) {
@@ -126,36 +252,200 @@ func findToken(token string, l []string) (int) {
 	return -1
 }
 
-func (r *CSVReader) initializeHeader(row []string) {
-	if r.columns == nil {
-		r.columns = row
+func (r *CSVReader) generateHeader(n int) ([]string) {
+	header := append([]string{}, r.names...)
+	for i:=len(header); i<n; i++ {
+		header = append(header, "c"+strconv.Itoa(i+1))
+	}
+	return header
+}
+
+func (r *CSVReader) newError(reason string) (*ParseError) {
+	return &ParseError{File: r.fname, Line: r.record_line, Reason: reason, Text: r.raw}
+}
+
+// provenance appends the synthetic source columns to a header or record of
+// nfields fields.
+func (r *CSVReader) provenance(row []string, filename string, lineno string) ([]string) {
+	if !r.with_filename && !r.with_lineno {
+		return row
+	}
+
+	out := make([]string, r.nfields, r.nfields+2)
+	copy(out, row)
+	if r.with_filename {
+		out = append(out, filename)
+	}
+	if r.with_lineno {
+		out = append(out, lineno)
+	}
+	return out
+}
+
+func (r *CSVReader) initializeHeader(row []string) (error) {
+	r.nfields = len(row)
+	row = r.uniqueHeader(r.renameHeader(row))
+	row = r.provenance(row, filename_column, lineno_column)
+	nsource := len(row)
+	if r.hook != nil {
+		var err error
+		if row, err = r.hook.prepareHeader(row); err != nil {
+			return err
+		}
 	}
+	r.fields = row
 
 	r.column_map = nil
-	for _,col := range(r.columns) {
-		idx := findToken(col, row)
-		if idx==-1 {
-			log.Fatal("specified column not found: ", col)
+	if r.columns == nil {
+		for i := range(row) {
+			r.column_map = append(r.column_map, i)
+		}
+	} else {
+		column_map, err := resolveColumns(r.columns, row)
+		if err != nil {
+			return r.newError(err.Error())
+		}
+		r.column_map = column_map
+
+		// derived columns not placed or excluded by the selection follow it
+		if nsource<len(row) {
+			placed := make(map[int]bool)
+			for _,i := range(column_map) {
+				placed[i] = true
+			}
+			kept, _ := resolveColumns(append(append([]string{}, r.columns...), "*"), row)
+			for _,i := range(kept) {
+				if i>=nsource && !placed[i] {
+					r.column_map = append(r.column_map, i)
+				}
+			}
 		}
-		r.header = append(r.header, col)
-		r.column_map = append(r.column_map, idx)
+	}
+
+	for _,idx := range(r.column_map) {
+		r.header = append(r.header, row[idx])
 	}
 
 	if len(r.header)==0 {
-		log.Fatal("None of the specified columns were found in the data")
+		return r.newError("none of the specified columns were found in the data")
 	}
 
 	r.ncols = len(r.header)
+	return nil
 }
 
-func (r *CSVReader) ParseLine(line string) (row []string) {
-	row = r.tokenize(line)
+func (r *CSVReader) completeRecord() (row []string) {
+	row = r.record
+	r.record = nil
+	r.ragged = nil
 
 	if len(r.header) == 0 {
-		r.initializeHeader(row)
+		if !r.no_header {
+			r.err = r.initializeHeader(row)
+			return nil
+		}
+
+		r.err = r.initializeHeader(r.generateHeader(len(row)))
+		if r.err != nil {
+			return nil
+		}
+	}
+
+	if len(row)!=r.nfields {
+		r.ragged = r.newError(fmt.Sprintf("expected %v fields, found %v", r.nfields, len(row)))
+	}
+
+	prepare := r.hook != nil && (r.ragged == nil || !r.reject_ragged)
+	if prepare {
+		row = r.fitRecord(row)
+	}
+	row = r.provenance(row, r.fname, strconv.Itoa(r.record_line))
+	if prepare {
+		if row, r.err = r.hook.prepareRecord(r.fields, row); r.err != nil || row == nil {
+			return nil
+		}
+	}
+	return r.normalizeRow(row)
+}
+
+// fitRecord pads or truncates a record to the fields of the header, so that
+// the derived cells appended to it line up with the derived columns.
+func (r *CSVReader) fitRecord(row []string) ([]string) {
+	if len(row)==r.nfields {
+		return row
+	}
+	out := make([]string, r.nfields)
+	copy(out, row)
+	return out
+}
+
+func (r *CSVReader) beginLine(line string) {
+	r.line++
+	if r.in_quote {
+		r.raw += "\n" + line
+	} else {
+		r.record_line = r.line
+		r.raw = line
+	}
+}
+
+// ignoreLine reports whether a line outside of any record is a comment or a
+// blank line to be dropped.
+func (r *CSVReader) ignoreLine(line string) (bool) {
+	if r.in_quote {
+		return false
+	}
+	if len(r.comment)>0 && strings.HasPrefix(line, r.comment) {
+		return true
+	}
+	return r.skip_blank && len(strings.TrimSpace(line))==0
+}
+
+func (r *CSVReader) ParseLine(line string) (row []string) {
+	line = strings.TrimSuffix(line, "\r")
+	if r.ignoreLine(line) {
+		r.line++
+		return nil
+	}
+
+	r.beginLine(line)
+	if !r.tokenize(line) {
 		return nil
 	}
 
-	row = r.normalizeRow(row)
+	return r.completeRecord()
+}
+
+func (r *CSVReader) IsPending() (bool) {
+	return r.in_quote
+}
+
+// Err returns the error which stopped reading, if any: a header that could
+// not be read or an error from the record hook.
+func (r *CSVReader) Err() (error) {
+	return r.err
+}
+
+// Ragged describes the last record if its field count differed from the
+// header; the returned row is still padded or truncated to fit.
+func (r *CSVReader) Ragged() (*ParseError) {
+	return r.ragged
+}
+
+// Flush completes a record whose quoted cell was never closed before the end
+// of input; the open cell takes the remainder of the input and Ragged
+// reports the record as unterminated.
+func (r *CSVReader) Flush() (row []string) {
+	if !r.in_quote {
+		return nil
+	}
+
+	r.in_quote = false
+	r.endToken()
+	row = r.completeRecord()
+	if row != nil {
+		reason := fmt.Sprintf("unterminated quoted field starting at line %v", r.record_line)
+		r.ragged = r.newError(reason)
+	}
 	return row
 }
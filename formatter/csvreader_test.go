@@ -3,6 +3,7 @@ package tabulon
 import (
 	"testing"
 	"reflect"
+	"strings"
 )
 
 func Test_newReader(t *testing.T) {
@@ -95,6 +96,10 @@ func Test_newReader(t *testing.T) {
 
 	// unterminated quote
 	row = reader.ParseLine(`long1,"long2,long3`)
+	if row != nil || !reader.IsPending() {
+		t.Error("unterminated quote should leave record pending")
+	}
+	row = reader.Flush()
 	expected = []string{`long1`, `long2,long3`, ``}
 	if !reflect.DeepEqual(row, expected) {
 		t.Error("row has incorrect content")
@@ -112,3 +117,426 @@ func Test_newReader(t *testing.T) {
 		t.Error("empty quoted first cell row: incorrect")
 	}
 }
+
+func Test_multilineRecord(t *testing.T) {
+	reader := NewCSVReader()
+	reader.ParseLine("id,note,status")
+
+	row := reader.ParseLine(`1,"first line`)
+	if row != nil || !reader.IsPending() {
+		t.Error("open quoted cell should leave record pending")
+	}
+	row = reader.ParseLine(`second line`)
+	if row != nil || !reader.IsPending() {
+		t.Error("record should still be pending")
+	}
+	row = reader.ParseLine(`third, line",open`)
+	expected := []string{"1", "first line\nsecond line\nthird, line", "open"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("multi-line record incorrect: ", row)
+	}
+	if reader.IsPending() {
+		t.Error("record should be complete")
+	}
+
+	row = reader.ParseLine(`2,"",closed`)
+	expected = []string{"2", "", "closed"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("row following multi-line record incorrect: ", row)
+	}
+
+	row = reader.ParseLine(`3,"`)
+	row = reader.ParseLine(`",closed`)
+	expected = []string{"3", "\n", "closed"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("newline-only cell incorrect: ", row)
+	}
+}
+
+func Test_multilineHeader(t *testing.T) {
+	reader := NewCSVReader()
+	if reader.ParseLine(`id,"long`) != nil {
+		t.Error("pending header should return nil")
+	}
+	reader.ParseLine(`name"`)
+	expected := []string{"id", "long\nname"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("multi-line header incorrect: ", reader.GetHeader())
+	}
+}
+
+func Test_crlf(t *testing.T) {
+	reader := NewCSVReader()
+	reader.ParseLine("h1,h2\r")
+	expected := []string{"h1", "h2"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("CRLF header incorrect: ", reader.GetHeader())
+	}
+
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.processFile(strings.NewReader("id,note\r\n1,\"a\r\nb\"\r\n2,c\r\n"), "test")
+	if table.nrows != 2 {
+		t.Fatal("CRLF input should produce 2 rows, got ", table.nrows)
+	}
+	if !reflect.DeepEqual(table.content[0], []string{"1", "a\nb"}) {
+		t.Error("CRLF multi-line cell incorrect: ", table.content[0])
+	}
+	if !reflect.DeepEqual(table.content[1], []string{"2", "c"}) {
+		t.Error("CRLF row incorrect: ", table.content[1])
+	}
+}
+
+func Test_unterminatedAtEOF(t *testing.T) {
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.processFile(strings.NewReader("id,note\n1,ok\n2,\"never\nclosed,x\n"), "test")
+	if table.nrows != 2 {
+		t.Fatal("unterminated quote at EOF should produce 2 rows, got ", table.nrows)
+	}
+	expected := []string{"2", "never\nclosed,x"}
+	if !reflect.DeepEqual(table.content[1], expected) {
+		t.Error("unterminated cell should take rest of input: ", table.content[1])
+	}
+
+	reader := NewCSVReader()
+	if reader.Flush() != nil {
+		t.Error("flush without pending record should return nil")
+	}
+}
+
+func Test_doubledQuotes(t *testing.T) {
+	reader := NewCSVReader()
+	reader.ParseLine("quote,other")
+
+	row := reader.ParseLine(`"He said ""hi""",x`)
+	expected := []string{`He said "hi"`, "x"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("doubled quotes not unescaped: ", row)
+	}
+
+	row = reader.ParseLine(`"""",""""""`)
+	expected = []string{`"`, `""`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("quote-only cells incorrect: ", row)
+	}
+
+	row = reader.ParseLine(`"a ""quoted,`)
+	row = reader.ParseLine(`cell""",y`)
+	expected = []string{"a \"quoted,\ncell\"", "y"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("doubled quotes across lines incorrect: ", row)
+	}
+}
+
+func Test_quoteAndEscape(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetQuote('\'')
+	reader.ParseLine("name,code")
+
+	row := reader.ParseLine(`'DOMINO''S, LIMITED',"DMP"`)
+	expected := []string{`DOMINO'S, LIMITED`, `"DMP"`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("single quote character incorrect: ", row)
+	}
+
+	reader = NewCSVReader()
+	reader.SetEscape('\\')
+	reader.ParseLine("name,code")
+
+	row = reader.ParseLine(`"say \"hi\"",a\,b`)
+	expected = []string{`say "hi"`, `a,b`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("backslash escapes incorrect: ", row)
+	}
+
+	row = reader.ParseLine(`"\\","x""y"`)
+	expected = []string{`\`, `x"y`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("escaped escape incorrect: ", row)
+	}
+
+	reader = NewCSVReader()
+	reader.SetEscape('"')
+	reader.ParseLine("name,code")
+	row = reader.ParseLine(`"a""b",c`)
+	expected = []string{`a"b`, `c`}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("escape equal to quote incorrect: ", row)
+	}
+}
+
+func Test_noHeader(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetDelimiter(':')
+	reader.SetNoHeader(true)
+
+	row := reader.ParseLine("root:x:0:0")
+	expected := []string{"root", "x", "0", "0"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("first row of headerless input should be data: ", row)
+	}
+	expected = []string{"c1", "c2", "c3", "c4"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("generated header incorrect: ", reader.GetHeader())
+	}
+
+	reader = NewCSVReader()
+	reader.SetHeaderNames([]string{"user", "pw"})
+	reader.SetColumns([]string{"c3", "user"})
+	row = reader.ParseLine("root,x,0")
+	expected = []string{"0", "root"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("named headerless row incorrect: ", row)
+	}
+	expected = []string{"c3", "user"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("named header incorrect: ", reader.GetHeader())
+	}
+
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.SetNoHeader(true)
+	table.SetMatchExpr("c2 > 1")
+	table.processFile(strings.NewReader("a,3\nb,1\nc,2\n"), "test")
+	if table.nrows != 2 {
+		t.Fatal("headerless table should keep 2 rows, got ", table.nrows)
+	}
+	table.SortByIndex(table.FindColumn("c2"))
+	if table.content[0][0] != "c" || table.content[1][0] != "a" {
+		t.Error("headerless sort incorrect: ", table.content)
+	}
+}
+
+func Test_raggedRows(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetFileName("in.csv")
+	reader.SetLineOffset(2)
+	reader.ParseLine("a,b")
+	if reader.ParseLine("1,2"); reader.Ragged() != nil {
+		t.Error("complete row reported as ragged")
+	}
+
+	reader.ParseLine(`3,"x`)
+	row := reader.ParseLine(`y"`)
+	if !reflect.DeepEqual(row, []string{"3", "x\ny"}) || reader.Ragged() != nil {
+		t.Error("multi-line row incorrect: ", row, reader.Ragged())
+	}
+
+	row = reader.ParseLine("4,5,6")
+	bad := reader.Ragged()
+	if bad == nil {
+		t.Fatal("long row not reported as ragged")
+	}
+	if bad.Line != 7 || bad.File != "in.csv" || bad.Text != "4,5,6" {
+		t.Error("ragged row error incorrect: ", bad)
+	}
+	if bad.Error() != "in.csv:7: expected 2 fields, found 3" {
+		t.Error("ragged row message incorrect: ", bad.Error())
+	}
+	if !reflect.DeepEqual(row, []string{"4", "5"}) {
+		t.Error("ragged row should still be truncated: ", row)
+	}
+
+	reader = NewCSVReader()
+	reader.SetColumns([]string{"a", "zz"})
+	if reader.ParseLine("a,b") != nil || reader.Err() == nil {
+		t.Error("missing column should be an error")
+	}
+}
+
+func Test_strictLenient(t *testing.T) {
+	input := "a,b\n1,2\n3\n4,5,6\n7,8\n"
+
+	table := NewTable()
+	table.SetDelimiter(',')
+	if err := table.processFile(strings.NewReader(input), "test"); err != nil {
+		t.Error("default mode should not fail: ", err)
+	}
+	if table.nrows != 4 {
+		t.Error("default mode should pad and keep ragged rows, got ", table.nrows)
+	}
+
+	table = NewTable()
+	table.SetDelimiter(',')
+	table.SetStrict(true)
+	err := table.processFile(strings.NewReader(input), "test")
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 3 {
+		t.Error("strict mode should fail on line 3: ", err)
+	}
+
+	var bad strings.Builder
+	table = NewTable()
+	table.SetDelimiter(',')
+	table.SetLenient(true, &bad)
+	if err := table.processFile(strings.NewReader(input), "test"); err != nil {
+		t.Error("lenient mode should not fail: ", err)
+	}
+	if table.nrows != 2 || table.BadRowCount() != 2 {
+		t.Error("lenient mode should reject 2 rows: ", table.nrows, table.BadRowCount())
+	}
+	if bad.String() != "3\n4,5,6\n" {
+		t.Error("rejected rows not written: ", bad.String())
+	}
+
+	table = NewTable()
+	table.SetDelimiter(',')
+	table.SetColumns([]string{"zz"})
+	err = table.processFile(strings.NewReader(input), "test")
+	if err == nil || err.Error() != "test:1: specified column not found: zz" {
+		t.Error("missing column error incorrect: ", err)
+	}
+
+	if table.SetMatchExpr("a ==") == nil {
+		t.Error("invalid expression should be an error")
+	}
+}
+
+func Test_provenance(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetFileName("a.csv")
+	reader.SetProvenance(true, true)
+	reader.ParseLine("id,note")
+	expected := []string{"id", "note", "_file", "_line"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("provenance header incorrect: ", reader.GetHeader())
+	}
+
+	row := reader.ParseLine(`1,"multi`)
+	row = reader.ParseLine(`line"`)
+	expected = []string{"1", "multi\nline", "a.csv", "2"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("provenance row incorrect: ", row)
+	}
+
+	row = reader.ParseLine("2")
+	expected = []string{"2", "", "a.csv", "4"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("provenance of short row incorrect: ", row)
+	}
+
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.SetSkip(1)
+	table.SetProvenance(false, true)
+	table.SetColumns([]string{"_line", "v"})
+	table.SetMatchExpr("_line > 3")
+	table.processFile(strings.NewReader("junk\nv\nx\ny\nz\n"), "b.csv")
+	content := [][]string{{"4", "y"}, {"5", "z"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Error("provenance columns not usable for selection and filtering: ", table.content)
+	}
+	if table.rowSource(1) != "5" {
+		t.Error("row source incorrect: ", table.rowSource(1))
+	}
+}
+
+func Test_commentsAndBlankLines(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetComment("#")
+	reader.SetSkipBlank(true)
+	if reader.ParseLine("# exported by instrument") != nil || reader.GetHeader() != nil {
+		t.Error("comment line should be ignored before the header")
+	}
+	reader.ParseLine("id,note")
+
+	if reader.ParseLine("") != nil || reader.ParseLine("  \r") != nil {
+		t.Error("blank lines should be ignored")
+	}
+
+	reader.ParseLine(`1,"text`)
+	row := reader.ParseLine("#not a comment")
+	if row != nil {
+		t.Error("record should still be pending")
+	}
+	row = reader.ParseLine(``)
+	row = reader.ParseLine(`end"`)
+	expected := []string{"1", "text\n#not a comment\n\nend"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("comment and blank lines inside quotes should be kept: ", row)
+	}
+
+	reader.ParseLine("#2,skipped")
+	row = reader.ParseLine("3,x")
+	if !reflect.DeepEqual(row, []string{"3", "x"}) {
+		t.Error("row after comment incorrect: ", row)
+	}
+	if reader.record_line != 10 {
+		t.Error("line numbers should count ignored lines: ", reader.record_line)
+	}
+
+	reader = NewCSVReader()
+	reader.ParseLine("id,note")
+	row = reader.ParseLine("")
+	if !reflect.DeepEqual(row, []string{"", ""}) {
+		t.Error("blank lines should be kept by default: ", row)
+	}
+}
+
+func Test_detectHeader(t *testing.T) {
+	input := "# instrument: X200\nSerial: 1234\n\nRun,3 of 4\ntime,temp,pressure\n0,21.5,1001\n# mid-run note\n1,21.7,1002\n\n2,21.6,1000\n"
+
+	table := NewTable()
+	table.SetComment("#")
+	table.SetSkipBlank(true)
+	table.SetDetectHeader(true)
+	if err := table.processFile(strings.NewReader(input), "run.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"time", "temp", "pressure"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Error("detected header incorrect: ", table.header)
+	}
+	if table.nrows != 3 || table.content[2][1] != "21.6" {
+		t.Error("content after detected header incorrect: ", table.content)
+	}
+	d := table.GetDialects()[0]
+	if d.delimiter != ',' || d.preamble != 4 {
+		t.Error("dialect with preamble incorrect: ", d)
+	}
+
+	table = NewTable()
+	table.SetDetectHeader(true)
+	table.processFile(strings.NewReader("a,b\n1,2\n3,4\n"), "plain.csv")
+	if table.GetDialects()[0].preamble != 0 || table.nrows != 2 {
+		t.Error("input without preamble should load unchanged: ", table.content)
+	}
+
+	table = NewTable()
+	table.SetDetectHeader(true)
+	table.processFile(strings.NewReader("Instrument X\nRun 5\n\nid,v\n\n1,2\n3,4\n"), "blank.csv")
+	if !reflect.DeepEqual(table.header, []string{"id", "v"}) || table.GetDialects()[0].preamble != 3 {
+		t.Error("blank line after the header hid it: ", table.header, table.GetDialects()[0])
+	}
+}
+
+func Test_unterminatedReported(t *testing.T) {
+	data := "id,note\n1,ok\n2,\"never\nclosed\n"
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.processFile(strings.NewReader(data), "test")
+	expected := []string{"test:3: unterminated quoted field starting at line 3"}
+	if table.nrows!=2 || !reflect.DeepEqual(table.Warnings(), expected) {
+		t.Errorf("unterminated record not kept with a warning: %v %v", table.nrows, table.Warnings())
+	}
+
+	table = NewTable()
+	table.SetDelimiter(',')
+	table.SetStrict(true)
+	err := table.processFile(strings.NewReader(data), "test")
+	if err == nil || err.Error()!="test:3: unterminated quoted field starting at line 3" {
+		t.Errorf("strict mode got %v", err)
+	}
+
+	var bad strings.Builder
+	table = NewTable()
+	table.SetDelimiter(',')
+	table.SetLenient(true, &bad)
+	if err := table.processFile(strings.NewReader(data), "test"); err != nil {
+		t.Fatal(err)
+	}
+	if table.nrows!=1 || table.BadRowCount()!=1 || bad.String()!="2,\"never\nclosed\n" {
+		t.Errorf("lenient mode kept %v rows, rejected %v: %q", table.nrows, table.BadRowCount(), bad.String())
+	}
+}
@@ -0,0 +1,121 @@
+package tabulon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// derivedColumn is a column computed from the other columns of each row by
+// an expression; it is appended to the header after the input columns.
+type derivedColumn struct {
+	name string
+	expr *expression
+	errors int
+	err string
+}
+
+// ParseDerive parses a name=EXPR column definition.
+func ParseDerive(spec string) (*derivedColumn, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts)!=2 || len(name)==0 {
+		return nil, errors.New("invalid derived column, expected name=EXPR: " + spec)
+	}
+
+	e, err := compileExpr(parts[1])
+	if err != nil {
+		return nil, errors.New(name + ": " + err.Error())
+	}
+	return &derivedColumn{name: name, expr: e}, nil
+}
+
+// formatValue is the cell text of an expression result.
+func formatValue(v interface{}) (string) {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		if x.Hour()==0 && x.Minute()==0 && x.Second()==0 && x.Nanosecond()==0 {
+			return x.Format("2006-01-02")
+		}
+		return x.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+func (table *Table) isDerived(col string) (bool) {
+	for _,d := range(table.derived) {
+		if d.name==col {
+			return true
+		}
+	}
+	return false
+}
+
+// deriveHeader returns the header of the current file with the derived
+// columns appended.
+func (table *Table) deriveHeader(header []string) ([]string, error) {
+	out := append([]string{}, header...)
+	for _,d := range(table.derived) {
+		if findToken(d.name, out)!=-1 {
+			return nil, &ParseError{File: table.fname, Reason: "derived column already exists: " + d.name}
+		}
+		out = append(out, d.name)
+	}
+	return out, nil
+}
+
+// deriveRow appends the derived columns to a record of the current file,
+// whose header including the derived columns is given. Each may refer to the
+// ones defined before it. A failing expression leaves the cell empty; the
+// failures are reported as a warning.
+func (table *Table) deriveRow(header []string, row []string) ([]string, error) {
+	row = append(row, make([]string, len(header)-len(row))...)
+	types := table.exprTypes(header)
+	for _,d := range(table.derived) {
+		idx := findToken(d.name, header)
+		v, err := d.expr.eval(row, header, types)
+		if err != nil {
+			if table.strict {
+				return nil, errors.New(table.fname + ": " + d.name + ": " + err.Error())
+			}
+
+			table.mu.Lock()
+			if d.errors==0 {
+				d.err = err.Error()
+			}
+			d.errors++
+			table.mu.Unlock()
+			continue
+		}
+		row[idx] = formatValue(v)
+	}
+	return row, nil
+}
+
+// SetDerive adds a column for each name=EXPR definition.
+func (table *Table) SetDerive(specs []string) (error) {
+	table.derived = nil
+	for _,spec := range(specs) {
+		d, err := ParseDerive(spec)
+		if err != nil {
+			return err
+		}
+		if table.isDerived(d.name) {
+			return errors.New("derived column defined twice: " + d.name)
+		}
+		table.derived = append(table.derived, d)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package tabulon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_derive(t *testing.T) {
+	table := NewTable()
+	err := table.SetDerive([]string{"margin=price - cost", "full=first + \" \" + last", "big=margin > 5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetMatchExpr("big == true"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "first,last,price,cost\nAda,Lovelace,10,2.5\nAlan,Turing,4,1\nGrace,Hopper,30,20\n")
+
+	expected := []string{"first", "last", "price", "cost", "margin", "full", "big"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Errorf("incorrect header %v", table.header)
+	}
+	content := [][]string{
+		{"Ada", "Lovelace", "10", "2.5", "7.5", "Ada Lovelace", "true"},
+		{"Grace", "Hopper", "30", "20", "10", "Grace Hopper", "true"},
+	}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Errorf("incorrect content %v", table.content)
+	}
+
+	table.SortByIndexReverse(table.FindColumn("margin"))
+	if table.content[0][0]!="Grace" {
+		t.Errorf("derived column sorted incorrectly: %v", table.content)
+	}
+}
+
+func Test_deriveMerge(t *testing.T) {
+	table := NewTable()
+	table.SetDerive([]string{"double=id * 2"})
+	err := loadFiles(&table, "id\n1\n", "id,name\n2,b\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"id", "name", "double"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Errorf("incorrect header %v", table.header)
+	}
+	content := [][]string{{"1", "", "2"}, {"2", "b", "4"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Errorf("incorrect content %v", table.content)
+	}
+}
+
+func Test_deriveErrors(t *testing.T) {
+	if _, err := ParseDerive("=1"); err == nil {
+		t.Error("derived column without name accepted")
+	}
+
+	table := NewTable()
+	table.SetDerive([]string{"x=nope + 1"})
+	loadString(t, &table, "a\n1\n2\n")
+	if len(table.content)!=2 || table.content[0][1]!="" {
+		t.Errorf("incorrect content %v", table.content)
+	}
+	expected := []string{"derived column x failed on 2 rows, which were left empty: unknown column in expression: nope"}
+	if !reflect.DeepEqual(table.Warnings(), expected) {
+		t.Errorf("got warnings %v", table.Warnings())
+	}
+
+	table = NewTable()
+	table.SetDerive([]string{"a=1"})
+	if err := loadFiles(&table, "a\n1\n"); err == nil {
+		t.Error("derived column replacing an input column accepted")
+	}
+}
+
+func Test_deriveSelectedColumns(t *testing.T) {
+	data := "item,price,cost\nbolt,10,4\nnut,3,1\n"
+	cases := []struct {
+		columns []string
+		header []string
+		content [][]string
+	}{
+		{[]string{"margin"}, []string{"margin"}, [][]string{{"6"}, {"2"}}},
+		{[]string{"price"}, []string{"price", "margin"}, [][]string{{"10", "6"}, {"3", "2"}}},
+		{[]string{"margin,item"}, []string{"margin", "item"}, [][]string{{"6", "bolt"}, {"2", "nut"}}},
+		{[]string{"!margin"}, []string{"item", "price", "cost"}, [][]string{{"bolt", "10", "4"}, {"nut", "3", "1"}}},
+	}
+
+	for _,c := range(cases) {
+		table := NewTable()
+		table.SetDerive([]string{"margin=price - cost"})
+		table.SetColumns(c.columns)
+		loadString(t, &table, data)
+		if !reflect.DeepEqual(table.header, c.header) {
+			t.Errorf("%v: incorrect header %v", c.columns, table.header)
+		}
+		if !reflect.DeepEqual(table.content, c.content) {
+			t.Errorf("%v: incorrect content %v", c.columns, table.content)
+		}
+	}
+}
+
+func Test_deriveRaggedRows(t *testing.T) {
+	data := "a,b,c\n1,2,3\n4,5,6,7,8\n9\n"
+	table := NewTable()
+	table.SetDerive([]string{"x=a + 1"})
+	loadString(t, &table, data)
+	content := [][]string{{"1", "2", "3", "2"}, {"4", "5", "6", "5"}, {"9", "", "", "10"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Errorf("incorrect content %v", table.content)
+	}
+
+	table = NewTable()
+	table.SetDerive([]string{"x=nope + 1"})
+	table.SetLenient(true, nil)
+	loadString(t, &table, data)
+	if len(table.content)!=1 || table.nbad!=2 {
+		t.Errorf("ragged rows not rejected: %v", table.content)
+	}
+	expected := []string{"derived column x failed on 1 rows, which were left empty: unknown column in expression: nope"}
+	if !reflect.DeepEqual(table.Warnings(), expected) {
+		t.Errorf("derived column evaluated on rejected rows: %v", table.Warnings())
+	}
+}
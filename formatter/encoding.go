@@ -0,0 +1,168 @@
+package tabulon
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var bom_utf8 = []byte{0xef, 0xbb, 0xbf}
+var bom_utf16le = []byte{0xff, 0xfe}
+var bom_utf16be = []byte{0xfe, 0xff}
+
+var encodings = map[string]string{
+	"": "",
+	"utf8": "utf8",
+	"latin1": "latin1",
+	"iso88591": "latin1",
+	"cp1252": "cp1252",
+	"windows1252": "cp1252",
+	"utf16": "utf16",
+	"utf16le": "utf16le",
+	"utf16be": "utf16be",
+}
+
+// cp1252 code points for 0x80-0x9f; the five unassigned bytes map to the
+// corresponding C1 control characters as in latin1
+var cp1252_high = [32]rune{
+	0x20ac, 0x0081, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008d, 0x017d, 0x008f,
+	0x0090, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0x009d, 0x017e, 0x0178,
+}
+
+// NormalizeEncoding maps an encoding name such as "ISO-8859-1" to the name
+// used internally, or returns false if the encoding is not supported.
+func NormalizeEncoding(name string) (string, bool) {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer("-", "", "_", "").Replace(name)
+	enc, ok := encodings[name]
+	return enc, ok
+}
+
+type decodingReader struct {
+	src *bufio.Reader
+	next func(*bufio.Reader) (rune, error)
+	out []byte
+	err error
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.out)<len(p) && d.err==nil {
+		c, err := d.next(d.src)
+		if err != nil {
+			// returned once the decoded output is used up
+			d.err = err
+			break
+		}
+		d.out = append(d.out, string(c)...)
+	}
+
+	if len(d.out)==0 {
+		return 0, d.err
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func nextLatin1(br *bufio.Reader) (rune, error) {
+	b, err := br.ReadByte()
+	return rune(b), err
+}
+
+func nextCP1252(br *bufio.Reader) (rune, error) {
+	b, err := br.ReadByte()
+	if b>=0x80 && b<0xa0 {
+		return cp1252_high[b-0x80], err
+	}
+	return rune(b), err
+}
+
+// nextUTF16 decodes UTF-16 code units. A surrogate that is not part of a
+// pair and an odd trailing byte decode to U+FFFD; a unit following an
+// unpaired high surrogate is decoded on its own.
+func nextUTF16(big_endian bool) (func(*bufio.Reader) (rune, error)) {
+	var pending rune = -1
+	unit := func(br *bufio.Reader) (rune, error) {
+		if pending!=-1 {
+			r := pending
+			pending = -1
+			return r, nil
+		}
+
+		var b [2]byte
+		if _, err := io.ReadFull(br, b[:]); err == io.ErrUnexpectedEOF {
+			return utf8.RuneError, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if big_endian {
+			return rune(b[0])<<8 | rune(b[1]), nil
+		}
+		return rune(b[1])<<8 | rune(b[0]), nil
+	}
+
+	return func(br *bufio.Reader) (rune, error) {
+		r1, err := unit(br)
+		if err != nil || !utf16.IsSurrogate(r1) {
+			return r1, err
+		}
+		if r1>=0xdc00 {
+			return utf8.RuneError, nil
+		}
+
+		r2, err := unit(br)
+		if err == io.EOF {
+			return utf8.RuneError, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		r := utf16.DecodeRune(r1, r2)
+		if r==utf8.RuneError {
+			pending = r2
+		}
+		return r, nil
+	}
+}
+
+// decodeInput converts the input to UTF-8. A byte order mark is stripped and,
+// unless latin1 or cp1252 is requested, decides between UTF-8 and UTF-16.
+func decodeInput(br *bufio.Reader, encoding string) (io.Reader, error) {
+	enc, ok := NormalizeEncoding(encoding)
+	if !ok {
+		return nil, errors.New("unsupported encoding: " + encoding)
+	}
+
+	if enc!="latin1" && enc!="cp1252" {
+		bom, _ := br.Peek(3)
+		if bytes.HasPrefix(bom, bom_utf8) {
+			br.Discard(len(bom_utf8))
+			enc = "utf8"
+		} else if bytes.HasPrefix(bom, bom_utf16le) {
+			br.Discard(len(bom_utf16le))
+			enc = "utf16le"
+		} else if bytes.HasPrefix(bom, bom_utf16be) {
+			br.Discard(len(bom_utf16be))
+			enc = "utf16be"
+		}
+	}
+
+	switch enc {
+	case "latin1":
+		return &decodingReader{src: br, next: nextLatin1}, nil
+	case "cp1252":
+		return &decodingReader{src: br, next: nextCP1252}, nil
+	case "utf16", "utf16le":
+		return &decodingReader{src: br, next: nextUTF16(false)}, nil
+	case "utf16be":
+		return &decodingReader{src: br, next: nextUTF16(true)}, nil
+	}
+
+	return br, nil
+}
@@ -0,0 +1,95 @@
+package tabulon
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func decodeString(t *testing.T, input []byte, encoding string) (string) {
+	r, err := decodeInput(bufio.NewReader(bytes.NewReader(input)), encoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	return string(out)
+}
+
+func Test_decodeInput(t *testing.T) {
+	if s := decodeString(t, []byte("\xef\xbb\xbfname,code\n"), ""); s != "name,code\n" {
+		t.Error("UTF-8 BOM not stripped: ", []byte(s))
+	}
+
+	utf16le := []byte{0xff, 0xfe, 'a', 0, ',', 0, 0xe9, 0, '\n', 0, 0x3d, 0xd8, 0x00, 0xde}
+	if s := decodeString(t, utf16le, ""); s != "a,é\n😀" {
+		t.Error("UTF-16LE not decoded: ", s)
+	}
+
+	utf16be := []byte{0xfe, 0xff, 0, 'a', 0, ',', 0, 'b'}
+	if s := decodeString(t, utf16be, "utf16"); s != "a,b" {
+		t.Error("UTF-16BE not decoded: ", s)
+	}
+
+	if s := decodeString(t, []byte{0, 'a', 0, 'b'}, "UTF-16BE"); s != "ab" {
+		t.Error("UTF-16BE without BOM not decoded: ", s)
+	}
+
+	if s := decodeString(t, []byte("caf\xe9 \x80"), "latin1"); s != "café \u0080" {
+		t.Error("latin1 not decoded: ", s)
+	}
+
+	if s := decodeString(t, []byte("caf\xe9 \x80 \x93q\x94"), "windows-1252"); s != "café € “q”" {
+		t.Error("cp1252 not decoded: ", s)
+	}
+
+	if _, err := decodeInput(bufio.NewReader(strings.NewReader("")), "ebcdic"); err == nil {
+		t.Error("unsupported encoding should fail")
+	}
+}
+
+func Test_bomHeader(t *testing.T) {
+	table := NewTable()
+	br, err := table.openInput(strings.NewReader("\xef\xbb\xbfname,code\nx,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table.SetDelimiter(',')
+	table.processFile(br, "test")
+	if table.FindColumn("name") != 0 {
+		t.Error("BOM should not be part of first header name: ", table.header)
+	}
+}
+
+func Test_utf16Invalid(t *testing.T) {
+	// unpaired high surrogate before 'a', unpaired low surrogate, odd byte
+	input := []byte{0xff, 0xfe, 0x3d, 0xd8, 'a', 0, 0x00, 0xde, 'b', 0, 'c'}
+	if s := decodeString(t, input, ""); s != "�a�b�" {
+		t.Errorf("got %q", s)
+	}
+
+	input = []byte{0xff, 0xfe, 'a', 0, 0x3d, 0xd8}
+	if s := decodeString(t, input, ""); s != "a�" {
+		t.Errorf("got %q", s)
+	}
+}
+
+func Test_utf16CorruptGzip(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte{0xff, 0xfe, 'a', 0, ',', 0, 'b', 0, '\n', 0})
+	w.Close()
+	data := buf.Bytes()
+	data[len(data)-8] ^= 0xff
+
+	table := NewTable()
+	r, err := table.openInput(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("checksum error of the compressed input was dropped")
+	}
+}
@@ -0,0 +1,29 @@
+package tabulon
+
+import (
+	"fmt"
+	"os"
+)
+
+// ParseError describes a problem with the input at a given line. Text holds
+// the raw record, which may span several lines.
+type ParseError struct {
+	File string
+	Line int
+	Reason string
+	Text string
+}
+
+func (e *ParseError) Error() (string) {
+	if e.Line>0 {
+		return fmt.Sprintf("%v:%v: %v", e.File, e.Line, e.Reason)
+	}
+	return fmt.Sprintf("%v: %v", e.File, e.Reason)
+}
+
+func newFileError(fname string, err error) (*ParseError) {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return &ParseError{File: fname, Reason: err.Error()}
+}
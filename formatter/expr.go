@@ -0,0 +1,474 @@
+package tabulon
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"github.com/danielgtaylor/mexpr"
+)
+
+// prefixes of the identifiers standing in for backticked column names and
+// for function calls
+const (
+	alias_prefix = "__col"
+	call_prefix = "__fn"
+)
+
+// identifiers with a fixed value unless a column has the same name, as
+// mexpr has no boolean literals
+var constants = map[string]interface{}{"true": true, "false": false}
+
+// expression is a compiled --expr. Column names that are not valid mexpr
+// identifiers can be written in backticks, as in `Unit Price ($)` > 5, and
+// registered functions can be called, as in lower(name) == "x"; both are
+// replaced by synthetic identifiers before parsing.
+type expression struct {
+	text string
+	ast *mexpr.Node
+	aliases map[string]string
+	columns []string
+	calls []*call
+}
+
+// call is a function call within an expression, made when the identifier
+// alias standing in for it is evaluated.
+type call struct {
+	name string
+	alias string
+	fn exprFunction
+	args []*mexpr.Node
+}
+
+// rewriteBackticks replaces backticked column names outside of string
+// literals by synthetic identifiers, returning the rewritten expression and
+// the column name of each identifier.
+func rewriteBackticks(text string) (string, map[string]string, error) {
+	var out strings.Builder
+	aliases := make(map[string]string)
+	names := make(map[string]string)
+	in_string := false
+	for i:=0; i<len(text); i++ {
+		c := text[i]
+		switch {
+		case in_string && c=='\\' && i+1<len(text):
+			out.WriteByte(c)
+			i++
+			c = text[i]
+		case c=='"':
+			in_string = !in_string
+		case c=='`' && !in_string:
+			end := strings.IndexByte(text[i+1:], '`')
+			if end==-1 {
+				return "", nil, errors.New("unterminated ` in expression")
+			}
+
+			name := text[i+1:i+1+end]
+			alias, ok := names[name]
+			if !ok {
+				alias = alias_prefix + strconv.Itoa(len(names))
+				names[name] = alias
+				aliases[alias] = name
+			}
+			out.WriteString(alias)
+			i += end+1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String(), aliases, nil
+}
+
+// identifiers collects the top level identifiers an expression refers to;
+// the right side of a field selection names a property, not a column.
+func identifiers(n *mexpr.Node, out []string) ([]string) {
+	if n == nil {
+		return out
+	}
+	if n.Type==mexpr.NodeIdentifier {
+		return append(out, n.Value.(string))
+	}
+
+	out = identifiers(n.Left, out)
+	if n.Type!=mexpr.NodeFieldSelect {
+		out = identifiers(n.Right, out)
+	}
+	return out
+}
+
+func isIdentStart(c byte) (bool) {
+	return c=='_' || (c>='a' && c<='z') || (c>='A' && c<='Z')
+}
+
+func isIdentChar(c byte) (bool) {
+	return isIdentStart(c) || (c>='0' && c<='9')
+}
+
+// expectsOperand reports whether an expression ending in prev continues with
+// an operand, so that name( starts a call rather than, as for startsWith,
+// the infix operator of the same name.
+func expectsOperand(prev string) (bool) {
+	prev = strings.TrimRight(prev, " \t\r\n")
+	if len(prev)==0 || strings.IndexByte("(,+-*/%^=<>![:", prev[len(prev)-1])!=-1 {
+		return true
+	}
+
+	k := len(prev)
+	for k>0 && isIdentChar(prev[k-1]) {
+		k--
+	}
+	switch prev[k:] {
+	case "and", "or", "not", "in", "startsWith", "endsWith":
+		return true
+	}
+	return false
+}
+
+// splitArgs splits the arguments of a call at the commas outside of nested
+// parentheses and strings; open is the position of the opening parenthesis.
+// It returns the position after the closing one.
+func splitArgs(text string, open int) (int, []string, error) {
+	var args []string
+	depth := 0
+	in_string := false
+	arg_start := open+1
+	for i:=open; i<len(text); i++ {
+		c := text[i]
+		switch {
+		case in_string && c=='\\':
+			i++
+		case c=='"':
+			in_string = !in_string
+		case in_string:
+		case c=='(':
+			depth++
+		case c==')':
+			depth--
+			if depth==0 {
+				args = append(args, text[arg_start:i])
+				if len(args)==1 && len(strings.TrimSpace(args[0]))==0 {
+					args = nil
+				}
+				return i+1, args, nil
+			}
+		case c==',' && depth==1:
+			args = append(args, text[arg_start:i])
+			arg_start = i+1
+		}
+	}
+	return 0, nil, errors.New("unterminated ( in expression")
+}
+
+// rewriteCalls replaces calls of registered functions outside of string
+// literals by synthetic identifiers. The arguments are compiled as
+// expressions of their own, in which nested calls are replaced the same way.
+func (e *expression) rewriteCalls(text string) (string, error) {
+	var out strings.Builder
+	in_string := false
+	for i:=0; i<len(text); {
+		c := text[i]
+		if in_string && c=='\\' && i+1<len(text) {
+			out.WriteString(text[i:i+2])
+			i += 2
+			continue
+		}
+		if c=='"' {
+			in_string = !in_string
+		}
+		if in_string || !isIdentStart(c) || (i>0 && (isIdentChar(text[i-1]) || text[i-1]=='.')) {
+			out.WriteByte(c)
+			i++
+			continue
+		}
+
+		j := i
+		for j<len(text) && isIdentChar(text[j]) {
+			j++
+		}
+		name := text[i:j]
+		fn, ok := functions[name]
+		if !ok || j>=len(text) || text[j]!='(' || !expectsOperand(out.String()) {
+			out.WriteString(name)
+			i = j
+			continue
+		}
+
+		end, args, err := splitArgs(text, j)
+		if err != nil {
+			return "", err
+		}
+		if err := fn.checkArgs(name, len(args)); err != nil {
+			return "", err
+		}
+
+		c_call := &call{name: name, fn: fn}
+		for _,arg := range(args) {
+			rewritten, err := e.rewriteCalls(arg)
+			if err != nil {
+				return "", err
+			}
+			ast, err := e.parse(rewritten)
+			if err != nil {
+				return "", err
+			}
+			c_call.args = append(c_call.args, ast)
+		}
+
+		c_call.alias = call_prefix + strconv.Itoa(len(e.calls))
+		e.calls = append(e.calls, c_call)
+		out.WriteString(c_call.alias)
+		i = end
+	}
+	return out.String(), nil
+}
+
+func (e *expression) findCall(id string) (*call) {
+	for _,c := range(e.calls) {
+		if c.alias==id {
+			return c
+		}
+	}
+	return nil
+}
+
+// intLiterals turns number literals written as integers, which mexpr parses
+// as float64, into int64 values so that they compare exactly with integer
+// cells.
+func intLiterals(n *mexpr.Node, src string) {
+	if n == nil {
+		return
+	}
+	if f, ok := n.Value.(float64); ok && n.Type==mexpr.NodeLiteral {
+		end := n.Offset
+		for end<len(src) && src[end]>='0' && src[end]<='9' {
+			end++
+		}
+		if end==len(src) || src[end]!='.' {
+			if i, err := strconv.ParseInt(src[n.Offset:end], 10, 64); err == nil && float64(i)==f {
+				n.Value = i
+			}
+		}
+	}
+	intLiterals(n.Left, src)
+	intLiterals(n.Right, src)
+}
+
+// parse parses a rewritten expression and records the columns it refers to.
+func (e *expression) parse(rewritten string) (*mexpr.Node, error) {
+	l := mexpr.NewLexer(rewritten)
+	p := mexpr.NewParser(l)
+	ast, err := p.Parse()
+	if err != nil {
+		return nil, errors.New(err.Pretty(rewritten))
+	}
+	intLiterals(ast, rewritten)
+
+	for _,id := range(identifiers(ast, nil)) {
+		if name, ok := e.aliases[id]; ok {
+			id = name
+		} else if _, ok := constants[id]; ok || e.findCall(id) != nil {
+			continue
+		}
+		if findToken(id, e.columns)==-1 {
+			e.columns = append(e.columns, id)
+		}
+	}
+	return ast, nil
+}
+
+func compileExpr(text string) (*expression, error) {
+	rewritten, aliases, err := rewriteBackticks(text)
+	if err != nil {
+		return nil, errors.New("invalid expression: " + err.Error())
+	}
+
+	e := &expression{text: text, aliases: aliases}
+	if rewritten, err = e.rewriteCalls(rewritten); err != nil {
+		return nil, errors.New("invalid expression: " + err.Error())
+	}
+
+	if e.ast, err = e.parse(rewritten); err != nil {
+		return nil, errors.New("invalid expression: " + err.Error())
+	}
+	return e, nil
+}
+
+// check returns an error naming the columns the expression refers to that
+// are not in the header.
+func (e *expression) check(header []string) (error) {
+	var missing []string
+	for _,col := range(e.columns) {
+		if findToken(col, header)==-1 {
+			missing = append(missing, col)
+		}
+	}
+
+	if len(missing)>0 {
+		return errors.New("unknown column in expression: " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// eval runs the expression on a row with the given header and column types.
+func (e *expression) eval(rec []string, header []string, types []ColumnType) (interface{}, error) {
+	// only the columns the expression refers to are converted
+	vars := make(map[string]interface{}, len(e.columns)+len(constants)+len(e.aliases))
+	for name,v := range(constants) {
+		vars[name] = v
+		if i := findToken(name, header); i!=-1 && i<len(rec) {
+			vars[name] = exprValue(types[i], rec[i])
+		}
+	}
+
+	for _,col := range(e.columns) {
+		i := findToken(col, header)
+		if i==-1 || i>=len(rec) {
+			return nil, errors.New("unknown column in expression: " + col)
+		}
+		vars[col] = exprValue(types[i], rec[i])
+	}
+
+	for alias,name := range(e.aliases) {
+		if v, ok := vars[name]; ok {
+			vars[alias] = v
+		}
+	}
+
+	return e.evalNode(e.ast, vars)
+}
+
+// evalCall calls a function with its arguments evaluated.
+func (e *expression) evalCall(c *call, vars map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i,arg := range(c.args) {
+		v, err := e.evalNode(arg, vars)
+		if err != nil {
+			return nil, errors.New(c.name + ": " + err.Error())
+		}
+		args[i] = v
+	}
+
+	v, err := c.fn.call(args)
+	if err != nil {
+		return nil, errors.New(c.name + ": " + err.Error())
+	}
+	return v, nil
+}
+
+// evalNode evaluates an expression tree. A call is only made when its value
+// is needed and and/or skip their right side once the left side decides the
+// result, so that a guard such as isEmpty(d) or year(d) > 2000 protects the
+// call. Comparisons are done here so that integers compare exactly; the
+// other operators are left to mexpr once their operands are evaluated.
+func (e *expression) evalNode(n *mexpr.Node, vars map[string]interface{}) (interface{}, error) {
+	switch n.Type {
+	case mexpr.NodeIdentifier:
+		if c := e.findCall(n.Value.(string)); c != nil {
+			return e.evalCall(c, vars)
+		}
+		return vars[n.Value.(string)], nil
+	case mexpr.NodeLiteral:
+		return n.Value, nil
+	case mexpr.NodeAnd, mexpr.NodeOr:
+		left, err := e.evalNode(n.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(left) == (n.Type==mexpr.NodeOr) {
+			return truthy(left), nil
+		}
+		right, err := e.evalNode(n.Right, vars)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(right), nil
+	case mexpr.NodeFieldSelect:
+		left, err := e.evalNode(n.Left, vars)
+		if err != nil {
+			return nil, err
+		}
+		return runNode(n.Right, left)
+	case mexpr.NodeSign:
+		right, err := e.evalNode(n.Right, vars)
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := right.(int64); ok {
+			if n.Value.(string)=="-" {
+				return -i, nil
+			}
+			return i, nil
+		}
+		return runNode(&mexpr.Node{Type: n.Type, Value: n.Value, Offset: n.Offset, Right: literal(n.Right, right)}, nil)
+	}
+
+	var left, right interface{}
+	var err error
+	if n.Left != nil {
+		if left, err = e.evalNode(n.Left, vars); err != nil {
+			return nil, err
+		}
+	}
+	if n.Right != nil {
+		if right, err = e.evalNode(n.Right, vars); err != nil {
+			return nil, err
+		}
+	}
+
+	switch n.Type {
+	case mexpr.NodeEqual, mexpr.NodeNotEqual, mexpr.NodeLessThan, mexpr.NodeLessThanEqual,
+		mexpr.NodeGreaterThan, mexpr.NodeGreaterThanEqual:
+		if c, ok := compareNumbers(left, right); ok {
+			switch n.Type {
+			case mexpr.NodeEqual:
+				return c==0, nil
+			case mexpr.NodeNotEqual:
+				return c!=0, nil
+			case mexpr.NodeLessThan:
+				return c<0, nil
+			case mexpr.NodeLessThanEqual:
+				return c<=0, nil
+			case mexpr.NodeGreaterThan:
+				return c>0, nil
+			}
+			return c>=0, nil
+		}
+	}
+	return runNode(&mexpr.Node{Type: n.Type, Value: n.Value, Offset: n.Offset,
+		Left: literal(n.Left, left), Right: literal(n.Right, right)}, nil)
+}
+
+// literal stands in for an evaluated operand.
+func literal(n *mexpr.Node, v interface{}) (*mexpr.Node) {
+	if n == nil {
+		return nil
+	}
+	return &mexpr.Node{Type: mexpr.NodeLiteral, Value: v, Offset: n.Offset}
+}
+
+func runNode(n *mexpr.Node, value interface{}) (interface{}, error) {
+	v, err := mexpr.NewInterpreter(n).Run(value)
+	if err != nil {
+		return nil, errors.New(err.Error())
+	}
+	return v, nil
+}
+
+// truthy converts a value to a boolean the way mexpr does: numbers are true
+// when positive, strings and arrays when not empty.
+func truthy(v interface{}) (bool) {
+	switch x := v.(type) {
+	case bool:
+		return x
+	case int64:
+		return x>0
+	case float64:
+		return x>0
+	case string:
+		return len(x)>0
+	case []interface{}:
+		return len(x)>0
+	case map[string]interface{}:
+		return len(x)>0
+	}
+	return false
+}
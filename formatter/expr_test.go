@@ -0,0 +1,85 @@
+package tabulon
+
+import (
+	"testing"
+	"reflect"
+	"strings"
+)
+
+func Test_rewriteBackticks(t *testing.T) {
+	rewritten, aliases, err := rewriteBackticks("`Unit Price ($)` > 5 and name == \"a `b` \\\"c\" and `Unit Price ($)` < 9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "__col0 > 5 and name == \"a `b` \\\"c\" and __col0 < 9"
+	if rewritten!=expected {
+		t.Errorf("got %v", rewritten)
+	}
+	if !reflect.DeepEqual(aliases, map[string]string{"__col0": "Unit Price ($)"}) {
+		t.Errorf("got aliases %v", aliases)
+	}
+
+	if _, _, err := rewriteBackticks("`open > 1"); err == nil {
+		t.Error("unterminated backtick accepted")
+	}
+}
+
+func Test_exprColumns(t *testing.T) {
+	e, err := compileExpr("`Unit Price` * qty > 5 and name.length > 2 and flag == true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.columns, []string{"Unit Price", "qty", "name", "flag"}) {
+		t.Errorf("got columns %v", e.columns)
+	}
+
+	if err := e.check([]string{"Unit Price", "qty", "name", "flag"}); err != nil {
+		t.Error(err)
+	}
+	if err := e.check([]string{"Unit Price", "name"}); err == nil || err.Error()!="unknown column in expression: qty, flag" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func Test_exprErrors(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("prce > 5"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "price\n10\n1\n")
+	if len(table.content)!=0 {
+		t.Errorf("rows kept despite failing expression: %v", table.content)
+	}
+	expected := []string{"expression failed on 2 rows, which were dropped: unknown column in expression: prce"}
+	if !reflect.DeepEqual(table.Warnings(), expected) {
+		t.Errorf("got warnings %v", table.Warnings())
+	}
+
+	table = NewTable()
+	table.SetStrict(true)
+	table.SetMatchExpr("prce > 5")
+	table.SetDelimiter(',')
+	if err := table.processFile(strings.NewReader("price\n10\n"), "t.csv"); err == nil {
+		t.Error("strict mode ignored the failing expression")
+	}
+
+	table = NewTable()
+	table.SetExprCheck(true)
+	table.SetMatchExpr("`Unit Price` > 5 and prce > 1")
+	table.SetDelimiter(',')
+	err := table.processFile(strings.NewReader("Unit Price\n10\n"), "t.csv")
+	if err == nil || err.Error()!="unknown column in expression: prce" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func Test_exprBackticks(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("`Unit Price ($)` >= 5 and ok == true"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "Unit Price ($),ok\n10,true\n1,true\n7,false\n")
+	if len(table.content)!=1 || table.content[0][0]!="10" {
+		t.Errorf("incorrect rows %v", table.content)
+	}
+}
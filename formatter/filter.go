@@ -0,0 +1,321 @@
+package tabulon
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filter is a --match or --remove condition. "col=value" tests a single
+// column for equality and "col~regex" matches a regular expression against
+// it; when col is not a column name, or the condition has neither form, the
+// whole text is searched for in the row joined with the delimiter. A regex
+// filter matches a regular expression against each cell of the row.
+type filter struct {
+	text string
+	column string
+	value string
+	re *regexp.Regexp
+	regex bool
+	ignore_case bool
+}
+
+// any_separator separates the conditions of an --any group.
+const any_separator = "||"
+
+func compileRegex(expr string, ignore_case bool) (*regexp.Regexp, error) {
+	if ignore_case {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+func parseFilter(text string, regex bool, ignore_case bool) (filter, error) {
+	f := filter{text: text, regex: regex, ignore_case: ignore_case}
+	if regex {
+		re, err := compileRegex(text, ignore_case)
+		if err != nil {
+			return f, errors.New("invalid regular expression " + text + ": " + err.Error())
+		}
+		f.re = re
+		return f, nil
+	}
+
+	if ignore_case {
+		f.text = strings.ToLower(text)
+	}
+
+	pos := strings.IndexAny(text, "=~")
+	if pos<=0 {
+		return f, nil
+	}
+
+	f.column = text[:pos]
+	f.value = text[pos+1:]
+	if text[pos]=='~' {
+		re, err := compileRegex(f.value, ignore_case)
+		if err != nil {
+			return f, errors.New("invalid regular expression in " + text + ": " + err.Error())
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+func parseFilters(texts []string, regex bool, ignore_case bool) ([]filter, error) {
+	var filters []filter
+	for _,text := range(texts) {
+		f, err := parseFilter(text, regex, ignore_case)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func (f *filter) matches(rec []string, header []string, delimiter rune) (bool) {
+	if f.regex {
+		for _,cell := range(rec) {
+			if f.re.MatchString(cell) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(f.column)>0 {
+		if idx := findToken(f.column, header); idx!=-1 && idx<len(rec) {
+			if f.re != nil {
+				return f.re.MatchString(rec[idx])
+			}
+			if f.ignore_case {
+				return strings.EqualFold(rec[idx], f.value)
+			}
+			return rec[idx]==f.value
+		}
+	}
+	return containsText(strings.Join(rec, string(delimiter)), f.text, f.ignore_case)
+}
+
+// containsText is a substring test; with ignore_case the substring has to
+// be in lower case already.
+func containsText(s string, substr string, ignore_case bool) (bool) {
+	if ignore_case {
+		s = strings.ToLower(s)
+	}
+	return strings.Contains(s, substr)
+}
+
+func (f *filter) String() (string) {
+	var s string
+	switch {
+	case f.regex:
+		s = fmt.Sprintf("any cell ~ /%v/", f.text)
+	case len(f.column)>0 && f.re != nil:
+		s = fmt.Sprintf("%v ~ /%v/", f.column, f.value)
+	case len(f.column)>0:
+		s = fmt.Sprintf("%v = %q", f.column, f.value)
+	default:
+		s = fmt.Sprintf("row contains %q", f.text)
+	}
+
+	if f.ignore_case {
+		s += " (ignore case)"
+	}
+	return s
+}
+
+type filterOp int
+const (
+	filterAnd filterOp = iota
+	filterOr
+	filterNot
+	filterTerm
+	filterExpr
+)
+
+// filterNode is a node of the tree combining all row filters.
+type filterNode struct {
+	op filterOp
+	children []*filterNode
+	term filter
+	expr string
+}
+
+// newGroup combines nodes with AND or OR, leaving out nil nodes; a group of
+// one node is that node.
+func newGroup(op filterOp, nodes ...*filterNode) (*filterNode) {
+	var children []*filterNode
+	for _,n := range(nodes) {
+		if n != nil {
+			children = append(children, n)
+		}
+	}
+
+	if len(children)==0 {
+		return nil
+	} else if len(children)==1 {
+		return children[0]
+	}
+	return &filterNode{op: op, children: children}
+}
+
+func termNodes(filters []filter) ([]*filterNode) {
+	var nodes []*filterNode
+	for _,f := range(filters) {
+		nodes = append(nodes, &filterNode{op: filterTerm, term: f})
+	}
+	return nodes
+}
+
+// eval applies the filter to a record of the current file with the given
+// header.
+func (n *filterNode) eval(rec []string, header []string, t *Table) (bool, error) {
+	switch n.op {
+	case filterAnd, filterOr:
+		for _,c := range(n.children) {
+			ok, err := c.eval(rec, header, t)
+			if err != nil {
+				return false, err
+			}
+			if ok == (n.op==filterOr) {
+				return ok, nil
+			}
+		}
+		return n.op==filterAnd, nil
+	case filterNot:
+		ok, err := n.children[0].eval(rec, header, t)
+		return !ok, err
+	case filterTerm:
+		return n.term.matches(rec, header, t.file_dialect.delimiter), nil
+	case filterExpr:
+		result, err := t.match_expr.eval(rec, header, t.exprTypes(header))
+		return result!=false, err
+	}
+	return true, nil
+}
+
+// unknownColumns collects the columns named by col=value and col~regex
+// conditions that are not in the header; such conditions search the whole
+// row instead.
+func (n *filterNode) unknownColumns(header []string, out []string) ([]string) {
+	if n.op==filterTerm && len(n.term.column)>0 && findToken(n.term.column, header)==-1 && findToken(n.term.column, out)==-1 {
+		out = append(out, n.term.column)
+	}
+	for _,c := range(n.children) {
+		out = c.unknownColumns(header, out)
+	}
+	return out
+}
+
+func (n *filterNode) explain(b *strings.Builder, indent string) {
+	b.WriteString(indent)
+	switch n.op {
+	case filterAnd:
+		b.WriteString("and\n")
+	case filterOr:
+		b.WriteString("or\n")
+	case filterNot:
+		b.WriteString("not\n")
+	case filterTerm:
+		b.WriteString(n.term.String() + "\n")
+	case filterExpr:
+		b.WriteString("expr " + n.expr + "\n")
+	}
+
+	for _,c := range(n.children) {
+		c.explain(b, indent + "  ")
+	}
+}
+
+// updateFilters compiles the match and remove conditions into the filter
+// tree: no row matching a remove condition, every match condition, at least
+// one condition of each --any group, and the expression.
+func (table *Table) updateFilters() (error) {
+	var match, remove, match_regex, remove_regex []filter
+	var err error
+	if match, err = parseFilters(table.match_text, false, table.ignore_case); err != nil {
+		return err
+	}
+	if remove, err = parseFilters(table.remove_text, false, table.ignore_case); err != nil {
+		return err
+	}
+	if match_regex, err = parseFilters(table.match_regex, true, table.ignore_case); err != nil {
+		return err
+	}
+	if remove_regex, err = parseFilters(table.remove_regex, true, table.ignore_case); err != nil {
+		return err
+	}
+
+	var nodes []*filterNode
+	removed := newGroup(filterOr, termNodes(append(remove, remove_regex...))...)
+	if removed != nil {
+		nodes = append(nodes, &filterNode{op: filterNot, children: []*filterNode{removed}})
+	}
+	nodes = append(nodes, termNodes(append(match, match_regex...))...)
+
+	for _,group := range(table.any) {
+		terms, err := parseFilters(strings.Split(group, any_separator), false, table.ignore_case)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, newGroup(filterOr, termNodes(terms)...))
+	}
+
+	if table.match_expr != nil {
+		nodes = append(nodes, &filterNode{op: filterExpr, expr: table.match_expr.text})
+	}
+
+	table.filter = newGroup(filterAnd, nodes...)
+	return nil
+}
+
+// ExplainFilter describes the filter tree applied to every row.
+func (table *Table) ExplainFilter() (string) {
+	if table.filter == nil {
+		return "all rows\n"
+	}
+
+	var b strings.Builder
+	table.filter.explain(&b, "")
+	return b.String()
+}
+
+// SetAny adds groups of conditions separated by ||, of which at least one has
+// to match.
+func (table *Table) SetAny(groups []string) (error) {
+	table.any = groups
+	return table.updateFilters()
+}
+
+func (table *Table) SetMatch(m []string) (error) {
+	table.match_text = m
+	return table.updateFilters()
+}
+
+func (table *Table) SetRemove(m []string) (error) {
+	table.remove_text = m
+	return table.updateFilters()
+}
+
+// SetMatchRegex keeps only rows where a cell matches each of the regular
+// expressions.
+func (table *Table) SetMatchRegex(m []string) (error) {
+	table.match_regex = m
+	return table.updateFilters()
+}
+
+// SetRemoveRegex drops rows where a cell matches any of the regular
+// expressions.
+func (table *Table) SetRemoveRegex(m []string) (error) {
+	table.remove_regex = m
+	return table.updateFilters()
+}
+
+// SetIgnoreCase makes filters and search case-insensitive.
+func (table *Table) SetIgnoreCase(ignore_case bool) (error) {
+	table.ignore_case = ignore_case
+	return table.updateFilters()
+}
@@ -0,0 +1,201 @@
+package tabulon
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_filterMatches(t *testing.T) {
+	header := []string{"country", "city"}
+	rec := []string{"DE", "AUgsburg"}
+	cases := []struct {
+		text string
+		expected bool
+	}{
+		{"AU", true},
+		{"country=AU", false},
+		{"country=DE", true},
+		{"city=AU", false},
+		{"city~^AU", true},
+		{"country~^(AT|DE)$", true},
+		{"E,A", true},
+		{"region=DE", false},
+		{"=DE", false},
+	}
+
+	for _,c := range(cases) {
+		f, err := parseFilter(c.text, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.matches(rec, header, ',') != c.expected {
+			t.Errorf("%q: expected %v", c.text, c.expected)
+		}
+	}
+
+	if _, err := parseFilter("city~(", false, false); err == nil {
+		t.Error("invalid regular expression accepted")
+	}
+}
+
+func Test_scopedFilters(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatch([]string{"country~^A"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetRemove([]string{"city=Graz"}); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "country,city\nAT,Wien\nAT,Graz\nAU,Perth\nDE,AUgsburg\n")
+	if len(table.content)!=2 || table.content[0][1]!="Wien" || table.content[1][1]!="Perth" {
+		t.Errorf("incorrect rows %v", table.content)
+	}
+}
+
+func Test_unknownFilterColumn(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatch([]string{"contry=AU", "city~^P"}); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "country,city\nAU,Perth\nAT,Pinkafeld\n")
+	warnings := table.Warnings()
+	if len(warnings)!=1 || warnings[0]!="unknown column contry in filter, searching the whole row instead" {
+		t.Errorf("got warnings %v", warnings)
+	}
+
+	table = NewTable()
+	table.SetExprCheck(true)
+	table.SetMatch([]string{"contry=AU"})
+	table.SetDelimiter(',')
+	err := table.processFile(strings.NewReader("country,city\nAU,Perth\n"), "t.csv")
+	if err == nil || err.Error()!="unknown column in filter: contry" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func Test_filterUnselectedColumn(t *testing.T) {
+	data := "ASX code,Company name,price\nMOQ,MOQ LIMITED,5\nABC,ADBRI LIMITED,20\nXYZ,XYZ LTD,30\n"
+	cases := []struct {
+		match []string
+		expr string
+		expected []string
+	}{
+		{[]string{"Company name=MOQ LIMITED"}, "", []string{"MOQ"}},
+		{nil, "price > 10", []string{"ABC", "XYZ"}},
+		{[]string{"Company name~LIMITED$"}, "price < 10", []string{"MOQ"}},
+	}
+
+	for _,c := range(cases) {
+		table := NewTable()
+		table.SetColumns([]string{"ASX code"})
+		if err := table.SetMatch(c.match); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.expr)>0 {
+			if err := table.SetMatchExpr(c.expr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		loadString(t, &table, data)
+
+		var got []string
+		for _,row := range(table.content) {
+			got = append(got, row[0])
+		}
+		if strings.Join(got, ",")!=strings.Join(c.expected, ",") || len(table.header)!=1 {
+			t.Errorf("%v %v: got %v %v", c.match, c.expr, table.header, got)
+		}
+		if len(table.Warnings())>0 {
+			t.Errorf("%v %v: got warnings %v", c.match, c.expr, table.Warnings())
+		}
+	}
+}
+
+func Test_regexAndIgnoreCase(t *testing.T) {
+	header := []string{"name", "city"}
+	rec := []string{"McDonald", "Wien"}
+	cases := []struct {
+		text string
+		regex bool
+		ignore_case bool
+		expected bool
+	}{
+		{"^mc", true, false, false},
+		{"^mc", true, true, true},
+		{"^Wien$", true, false, true},
+		{"d,w", false, true, true},
+		{"name=mcdonald", false, true, true},
+		{"name=mcdonald", false, false, false},
+		{"city~^WIEN", false, true, true},
+		{"Donald,Wien", true, false, false},
+	}
+
+	for _,c := range(cases) {
+		f, err := parseFilter(c.text, c.regex, c.ignore_case)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.matches(rec, header, ',') != c.expected {
+			t.Errorf("%q regex=%v ignore_case=%v: expected %v", c.text, c.regex, c.ignore_case, c.expected)
+		}
+	}
+
+	if _, err := parseFilter("[", true, false); err == nil {
+		t.Error("invalid regular expression accepted")
+	}
+}
+
+func Test_ignoreCaseSearch(t *testing.T) {
+	table := NewTable()
+	if err := table.SetRemoveRegex([]string{"^x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetIgnoreCase(true); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "name\nXaver\nanna\nSMITH\nsmith\n")
+	if len(table.content)!=3 {
+		t.Fatalf("case-insensitive remove failed: %v", table.content)
+	}
+	if y := table.Search(0, "Smith"); y!=1 {
+		t.Errorf("search found row %v", y)
+	}
+	if y := table.SearchReverse(2, "ANNA"); y!=0 {
+		t.Errorf("reverse search found row %v", y)
+	}
+}
+
+func Test_anyGroups(t *testing.T) {
+	table := NewTable()
+	if err := table.SetAny([]string{"country=AU||country=NZ"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetRemove([]string{"status=closed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetMatchExpr("n > 1"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "country,status,n\nAU,open,2\nNZ,closed,3\nDE,open,4\nNZ,open,5\nAU,open,1\n")
+	if len(table.content)!=2 || table.content[0][2]!="2" || table.content[1][2]!="5" {
+		t.Errorf("incorrect rows %v", table.content)
+	}
+
+	expected := "and\n" +
+		"  not\n" +
+		"    status = \"closed\"\n" +
+		"  or\n" +
+		"    country = \"AU\"\n" +
+		"    country = \"NZ\"\n" +
+		"  expr n > 1\n"
+	if explain := table.ExplainFilter(); explain!=expected {
+		t.Errorf("incorrect explanation:\n%v", explain)
+	}
+}
+
+func Test_explainNoFilter(t *testing.T) {
+	table := NewTable()
+	if table.ExplainFilter()!="all rows\n" {
+		t.Errorf("incorrect explanation %q", table.ExplainFilter())
+	}
+}
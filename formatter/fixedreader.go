@@ -0,0 +1,84 @@
+package tabulon
+
+import (
+	"strings"
+)
+
+// FixedWidthReader splits lines at fixed column positions. Header handling,
+// column selection and row normalization are shared with CSVReader.
+type FixedWidthReader struct {
+	CSVReader
+	starts []int
+}
+
+// NewFixedWidthReader takes the width of each column; the last column
+// extends to the end of the line.
+func NewFixedWidthReader(widths []int) (FixedWidthReader) {
+	r := FixedWidthReader{CSVReader: NewCSVReader()}
+	pos := 0
+	for _,w := range(widths) {
+		r.starts = append(r.starts, pos)
+		pos += w
+	}
+	return r
+}
+
+func (r *FixedWidthReader) tokenize(line string) {
+	runes := []rune(line)
+	N := len(runes)
+	for i,start := range(r.starts) {
+		end := N
+		if i+1<len(r.starts) {
+			end = int_min(N, r.starts[i+1])
+		}
+
+		token := ""
+		if start<end {
+			token = strings.TrimSpace(string(runes[start:end]))
+		}
+		r.record = r.appendToken(r.record, token)
+	}
+}
+
+func (r *FixedWidthReader) ParseLine(line string) (row []string) {
+	line = strings.TrimSuffix(line, "\r")
+	if r.ignoreLine(line) {
+		r.line++
+		return nil
+	}
+
+	r.beginLine(line)
+	r.tokenize(line)
+	return r.completeRecord()
+}
+
+// InferWidths finds column boundaries from runs of whitespace which line up
+// across all sample lines; a column starts wherever a position that is blank
+// in every line is followed by one that is not.
+func InferWidths(lines []string) ([]int) {
+	var used []bool
+	for _,line := range(lines) {
+		for i,c := range([]rune(strings.TrimRight(line, "\r"))) {
+			if i>=len(used) {
+				used = append(used, false)
+			}
+			if c!=' ' && c!='\t' {
+				used[i] = true
+			}
+		}
+	}
+
+	var widths []int
+	start := 0
+	for i:=1; i<len(used); i++ {
+		if used[i] && !used[i-1] {
+			widths = append(widths, i-start)
+			start = i
+		}
+	}
+
+	if len(used)>start {
+		widths = append(widths, len(used)-start)
+	}
+	return widths
+}
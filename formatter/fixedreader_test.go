@@ -0,0 +1,69 @@
+package tabulon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_fixedWidthReader(t *testing.T) {
+	reader := NewFixedWidthReader([]int{6, 4, 10})
+	if reader.ParseLine("NAME  QTY DESC") != nil {
+		t.Error("header read incorrect")
+	}
+	expected := []string{"NAME", "QTY", "DESC"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Error("header not correct: ", reader.GetHeader())
+	}
+
+	row := reader.ParseLine("widg  10  small thing with long text")
+	expected = []string{"widg", "10", "small thing with long text"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("row has incorrect content: ", row)
+	}
+
+	row = reader.ParseLine("ab")
+	expected = []string{"ab", "", ""}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("short row has incorrect content: ", row)
+	}
+
+	row = reader.ParseLine("ünï   1   x\r")
+	expected = []string{"ünï", "1", "x"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Error("multibyte row has incorrect content: ", row)
+	}
+}
+
+func Test_inferWidths(t *testing.T) {
+	lines := []string{
+		"NAME      QTY  DESCRIPTION",
+		"widget    10   small blue thing",
+		"gizmo     200  big one",
+	}
+	widths := InferWidths(lines)
+	expected := []int{10, 5, 16}
+	if !reflect.DeepEqual(widths, expected) {
+		t.Error("inferred widths incorrect: ", widths)
+	}
+
+	if InferWidths(nil) != nil {
+		t.Error("empty sample should infer no widths")
+	}
+}
+
+func Test_fixedWidthTable(t *testing.T) {
+	table := NewTable()
+	table.SetFixedWidths([]int{3, 4})
+	table.SetColumns([]string{"v", "k"})
+	table.SetMatchExpr("v > 5")
+	table.processFile(strings.NewReader("k  v\na  1\nb  7\nc  12\n"), "test")
+	if table.nrows != 2 {
+		t.Fatal("fixed-width table should have 2 rows, got ", table.nrows)
+	}
+	table.SortByIndexReverse(table.FindColumn("v"))
+	expected := [][]string{{"12", "c"}, {"7", "b"}}
+	if !reflect.DeepEqual(table.content, expected) {
+		t.Error("fixed-width table content incorrect: ", table.content)
+	}
+}
@@ -0,0 +1,284 @@
+package tabulon
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Function is a function callable from expressions. Integers are passed as
+// int64, other numbers as float64 and cells of time columns as strings.
+type Function func(args []interface{}) (interface{}, error)
+
+type exprFunction struct {
+	min_args int
+	max_args int
+	call Function
+}
+
+var functions = make(map[string]exprFunction)
+
+// RegisterFunction makes a function with min_args to max_args arguments
+// available to expressions; max_args -1 means any number.
+func RegisterFunction(name string, min_args int, max_args int, call Function) {
+	functions[name] = exprFunction{min_args, max_args, call}
+}
+
+func (f *exprFunction) checkArgs(name string, n int) (error) {
+	if n>=f.min_args && (f.max_args==-1 || n<=f.max_args) {
+		return nil
+	}
+
+	expected := strconv.Itoa(f.min_args)
+	if f.max_args==-1 {
+		expected = "at least " + expected
+	} else if f.max_args!=f.min_args {
+		expected += " to " + strconv.Itoa(f.max_args)
+	}
+	return fmt.Errorf("%v expects %v arguments, found %v", name, expected, n)
+}
+
+func argString(v interface{}) (string) {
+	return formatValue(v)
+}
+
+func argNumber(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case int64:
+		return float64(x), nil
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(x), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("not a number: %q", argString(v))
+}
+
+func argTime(v interface{}) (time.Time, error) {
+	switch x := v.(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		if t, ok := parseTime(strings.TrimSpace(x)); ok {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("not a date: %q", argString(v))
+}
+
+// compiled regular expressions by pattern
+var regex_cache = struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}{m: make(map[string]*regexp.Regexp)}
+
+func argRegex(v interface{}) (*regexp.Regexp, error) {
+	pattern := argString(v)
+	regex_cache.Lock()
+	defer regex_cache.Unlock()
+	if re, ok := regex_cache.m[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("invalid regular expression: " + pattern)
+	}
+	regex_cache.m[pattern] = re
+	return re, nil
+}
+
+func stringFunction(f func(string) string) (Function) {
+	return func(args []interface{}) (interface{}, error) {
+		return f(argString(args[0])), nil
+	}
+}
+
+func stringTest(f func(string, string) bool) (Function) {
+	return func(args []interface{}) (interface{}, error) {
+		return f(argString(args[0]), argString(args[1])), nil
+	}
+}
+
+func mathFunction(f func(float64) float64) (Function) {
+	return func(args []interface{}) (interface{}, error) {
+		x, err := argNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return f(x), nil
+	}
+}
+
+func timeField(f func(time.Time) int) (Function) {
+	return func(args []interface{}) (interface{}, error) {
+		t, err := argTime(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return float64(f(t)), nil
+	}
+}
+
+// substr returns length runes of s from start, counting from 0; a negative
+// start counts from the end.
+func substr(args []interface{}) (interface{}, error) {
+	s := []rune(argString(args[0]))
+	start, err := argNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	from := int(start)
+	if from<0 {
+		from += len(s)
+	}
+	if from<0 {
+		from = 0
+	} else if from>len(s) {
+		from = len(s)
+	}
+
+	to := len(s)
+	if len(args)>2 {
+		length, err := argNumber(args[2])
+		if err != nil {
+			return nil, err
+		}
+		if from+int(length)<to {
+			to = from+int(length)
+		}
+	}
+	if to<from {
+		to = from
+	}
+	return string(s[from:to]), nil
+}
+
+func round(args []interface{}) (interface{}, error) {
+	x, err := argNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	digits := 0.0
+	if len(args)>1 {
+		if digits, err = argNumber(args[1]); err != nil {
+			return nil, err
+		}
+	}
+	scale := math.Pow(10, digits)
+	return math.Round(x*scale)/scale, nil
+}
+
+func logarithm(args []interface{}) (interface{}, error) {
+	x, err := argNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(args)==1 {
+		return math.Log(x), nil
+	}
+
+	base, err := argNumber(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return math.Log(x)/math.Log(base), nil
+}
+
+// date parses a date or time, optionally with a Go reference time layout.
+func date(args []interface{}) (interface{}, error) {
+	if len(args)==1 {
+		return argTime(args[0])
+	}
+
+	s := strings.TrimSpace(argString(args[0]))
+	t, err := time.Parse(argString(args[1]), s)
+	if err != nil {
+		return nil, fmt.Errorf("not a date: %q", s)
+	}
+	return t, nil
+}
+
+func diffDays(args []interface{}) (interface{}, error) {
+	a, err := argTime(args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := argTime(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return a.Sub(b).Hours()/24, nil
+}
+
+func init() {
+	RegisterFunction("lower", 1, 1, stringFunction(strings.ToLower))
+	RegisterFunction("upper", 1, 1, stringFunction(strings.ToUpper))
+	RegisterFunction("trim", 1, 1, stringFunction(strings.TrimSpace))
+	RegisterFunction("substr", 2, 3, substr)
+	RegisterFunction("contains", 2, 2, stringTest(strings.Contains))
+	RegisterFunction("startsWith", 2, 2, stringTest(strings.HasPrefix))
+	RegisterFunction("endsWith", 2, 2, stringTest(strings.HasSuffix))
+	RegisterFunction("len", 1, 1, func(args []interface{}) (interface{}, error) {
+		return float64(len([]rune(argString(args[0])))), nil
+	})
+	RegisterFunction("matches", 2, 2, func(args []interface{}) (interface{}, error) {
+		re, err := argRegex(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(argString(args[0])), nil
+	})
+	RegisterFunction("replace", 3, 3, func(args []interface{}) (interface{}, error) {
+		re, err := argRegex(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return re.ReplaceAllString(argString(args[0]), argString(args[2])), nil
+	})
+
+	RegisterFunction("abs", 1, 1, mathFunction(math.Abs))
+	RegisterFunction("floor", 1, 1, mathFunction(math.Floor))
+	RegisterFunction("ceil", 1, 1, mathFunction(math.Ceil))
+	RegisterFunction("round", 1, 2, round)
+	RegisterFunction("log", 1, 2, logarithm)
+	RegisterFunction("number", 1, 1, func(args []interface{}) (interface{}, error) {
+		return argNumber(args[0])
+	})
+
+	// cells are never missing, so null and empty both mean an empty cell;
+	// isEmpty also accepts whitespace
+	RegisterFunction("isNull", 1, 1, func(args []interface{}) (interface{}, error) {
+		return args[0]==nil || argString(args[0])=="", nil
+	})
+	RegisterFunction("isEmpty", 1, 1, func(args []interface{}) (interface{}, error) {
+		return len(strings.TrimSpace(argString(args[0])))==0, nil
+	})
+	RegisterFunction("coalesce", 1, -1, func(args []interface{}) (interface{}, error) {
+		for _,arg := range(args) {
+			if len(argString(arg))>0 {
+				return arg, nil
+			}
+		}
+		return "", nil
+	})
+
+	RegisterFunction("date", 1, 2, date)
+	RegisterFunction("year", 1, 1, timeField(func(t time.Time) int { return t.Year() }))
+	RegisterFunction("month", 1, 1, timeField(func(t time.Time) int { return int(t.Month()) }))
+	RegisterFunction("day", 1, 1, timeField(func(t time.Time) int { return t.Day() }))
+	RegisterFunction("diffDays", 2, 2, diffDays)
+	RegisterFunction("today", 0, 0, func(args []interface{}) (interface{}, error) {
+		y, m, d := time.Now().Date()
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC), nil
+	})
+}
@@ -0,0 +1,141 @@
+package tabulon
+
+import (
+	"testing"
+	"reflect"
+)
+
+func Test_functions(t *testing.T) {
+	header := []string{"name", "price", "when", "empty"}
+	types := []ColumnType{TypeString, TypeFloat, TypeTime, TypeString}
+	rec := []string{"  Ada Lovelace ", "-2.345", "2024-03-15", ""}
+	cases := []struct {
+		expr string
+		expected interface{}
+	}{
+		{`lower(trim(name))`, "ada lovelace"},
+		{`upper(substr(trim(name), 0, 3))`, "ADA"},
+		{`substr(trim(name), -8)`, "Lovelace"},
+		{`contains(name, "Love") and startsWith(trim(name), "Ada")`, true},
+		{`endsWith(name, "x")`, false},
+		{`name startsWith ("  A")`, true},
+		{`matches(name, "^\s+Ada")`, true},
+		{`replace(trim(name), "[aeiou]", "_")`, "Ad_ L_v_l_c_"},
+		{`len(trim(name))`, 12.0},
+		{`abs(price)`, 2.345},
+		{`round(price, 2)`, -2.35},
+		{`floor(price)`, -3.0},
+		{`ceil(abs(price))`, 3.0},
+		{`round(log(100, 10))`, 2.0},
+		{`isNull(empty) and isEmpty(" ") and not isNull(name)`, true},
+		{`coalesce(empty, "n/a")`, "n/a"},
+		{`year(when) * 100 + month(when)`, 202403.0},
+		{`day(date("15/03/2024", "02/01/2006"))`, 15.0},
+		{`diffDays(when, "2024-03-01")`, 14.0},
+		{`number("12") + 1`, 13.0},
+	}
+
+	for _,c := range(cases) {
+		e, err := compileExpr(c.expr)
+		if err != nil {
+			t.Errorf("%v: %v", c.expr, err)
+			continue
+		}
+		v, err := e.eval(rec, header, types)
+		if err != nil {
+			t.Errorf("%v: %v", c.expr, err)
+		} else if !reflect.DeepEqual(v, c.expected) {
+			t.Errorf("%v: got %#v, expected %#v", c.expr, v, c.expected)
+		}
+	}
+}
+
+func Test_functionErrors(t *testing.T) {
+	for _,expr := range([]string{`lower()`, `substr(name)`, `lower(name`, `lower(name, "x")`}) {
+		if _, err := compileExpr(expr); err == nil {
+			t.Errorf("%v: accepted", expr)
+		}
+	}
+
+	e, err := compileExpr(`year(name) > 2000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = e.eval([]string{"x"}, []string{"name"}, []ColumnType{TypeString})
+	if err == nil || err.Error()!=`year: not a date: "x"` {
+		t.Errorf("got %v", err)
+	}
+
+	e, err = compileExpr(`lower(nmae) == "x" and "lower(name)" == name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(e.columns, []string{"nmae", "name"}) {
+		t.Errorf("got columns %v", e.columns)
+	}
+}
+
+func Test_functionsTestdata(t *testing.T) {
+	cases := []struct {
+		file string
+		expr string
+		expected int
+	}{
+		{"../testdata/ASXListedCompanies.csv", "endsWith(`Company name`, \"LIMITED\")", 1514},
+		{"../testdata/diamonds.csv", `lower(cut) == "ideal" and price > 18000`, 105},
+		{"../testdata/diamonds.csv", `round(carat) >= 3 and upper(cut) == "PREMIUM"`, 50},
+	}
+
+	for _,c := range(cases) {
+		table := NewTable()
+		if err := table.SetMatchExpr(c.expr); err != nil {
+			t.Fatal(err)
+		}
+		if err := table.ReadFiles([]string{c.file}); err != nil {
+			t.Fatal(err)
+		}
+		if len(table.content)!=c.expected || len(table.Warnings())!=0 {
+			t.Errorf("%v: got %v rows, expected %v; %v", c.expr, len(table.content), c.expected, table.Warnings())
+		}
+	}
+
+	table := NewTable()
+	table.SetDerive([]string{"short=replace(`Company name`, \" LIMITED$\", \"\")"})
+	table.SetHead(1)
+	if err := table.ReadFiles([]string{"../testdata/ASXListedCompanies.csv"}); err != nil {
+		t.Fatal(err)
+	}
+	if table.content[0][3]!="MOQ" {
+		t.Errorf("incorrect derived column %v", table.content[0])
+	}
+}
+
+func Test_guardedCalls(t *testing.T) {
+	cases := []struct {
+		expr string
+		expected []string
+	}{
+		{`isEmpty(d) or year(d) > 2000`, []string{"", "2024-01-31"}},
+		{`d != "" and year(d) > 2000`, []string{"2024-01-31"}},
+		{`not isEmpty(d) and year(d) < 2000`, []string{"1999-12-31"}},
+	}
+
+	for _,c := range(cases) {
+		table := NewTable()
+		if err := table.SetMatchExpr(c.expr); err != nil {
+			t.Fatal(err)
+		}
+		loadString(t, &table, "id,d\n1,\n2,2024-01-31\n3,1999-12-31\n")
+
+		var got []string
+		for _,row := range(table.content) {
+			got = append(got, row[1])
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%v: got %q, expected %q", c.expr, got, c.expected)
+		}
+		if len(table.Warnings())>0 {
+			t.Errorf("%v: got warnings %v", c.expr, table.Warnings())
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package tabulon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// NormalizeName converts a column name to snake_case: "Unit Price ($)"
+// becomes unit_price and "orderDate" becomes order_date.
+func NormalizeName(name string) (string) {
+	var out []rune
+	var prev rune
+	for _,c := range(strings.TrimSpace(name)) {
+		switch {
+		case unicode.IsUpper(c):
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+				out = append(out, '_')
+			}
+			out = append(out, unicode.ToLower(c))
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			out = append(out, c)
+		default:
+			if len(out)>0 && out[len(out)-1]!='_' {
+				out = append(out, '_')
+			}
+		}
+		prev = c
+	}
+	return strings.Trim(string(out), "_")
+}
+
+// uniqueNames suffixes repeated names with _2, _3, ... so that every column
+// can be addressed by name.
+func uniqueNames(names []string) ([]string) {
+	taken := make(map[string]bool)
+	for _,name := range(names) {
+		taken[name] = true
+	}
+
+	out := make([]string, len(names))
+	seen := make(map[string]bool)
+	for i,name := range(names) {
+		if seen[name] {
+			n := 2
+			for taken[name+"_"+strconv.Itoa(n)] {
+				n++
+			}
+			out[i] = name+"_"+strconv.Itoa(n)
+			taken[out[i]] = true
+		} else {
+			out[i] = name
+		}
+		seen[name] = true
+	}
+	return out
+}
+
+// ParseRenames parses old=new pairs.
+func ParseRenames(specs []string) (map[string]string, error) {
+	renames := make(map[string]string)
+	for _,spec := range(specs) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts)!=2 || len(parts[0])==0 || len(parts[1])==0 {
+			return nil, errors.New("invalid rename, expected old=new: " + spec)
+		}
+		renames[parts[0]] = parts[1]
+	}
+	return renames, nil
+}
+
+// renameHeader applies the renames and, if enabled, normalization to the
+// names of the input columns. A rename may refer to a column by its original
+// or by its normalized name; renamed columns are not normalized further.
+func (r *CSVReader) renameHeader(row []string) ([]string) {
+	if len(r.renames)==0 && !r.normalize {
+		return row
+	}
+
+	out := make([]string, len(row))
+	for i,name := range(row) {
+		normalized := name
+		if r.normalize {
+			normalized = NormalizeName(name)
+			if len(normalized)==0 {
+				normalized = "c" + strconv.Itoa(i+1)
+			}
+		}
+
+		if renamed, ok := r.renames[name]; ok {
+			out[i] = renamed
+		} else if renamed, ok := r.renames[normalized]; ok {
+			out[i] = renamed
+		} else {
+			out[i] = normalized
+		}
+	}
+	return out
+}
+
+// uniqueHeader makes repeated column names unique and records a warning
+// for each column that had to be renamed.
+func (r *CSVReader) uniqueHeader(row []string) ([]string) {
+	unique := uniqueNames(row)
+	for i := range(row) {
+		if unique[i]!=row[i] {
+			reason := fmt.Sprintf("duplicate column %v renamed to %v", row[i], unique[i])
+			r.warnings = append(r.warnings, r.newError(reason).Error())
+		}
+	}
+	return unique
+}
+
+// Warnings returns the problems found in the header that did not prevent
+// reading the file.
+func (r *CSVReader) Warnings() ([]string) {
+	return r.warnings
+}
+
+func (c *CSVReader) SetRenames(renames map[string]string) {
+	c.renames = renames
+}
+
+func (c *CSVReader) SetNormalizeHeaders(normalize bool) {
+	c.normalize = normalize
+}
+
+func (table *Table) SetRenames(specs []string) (error) {
+	renames, err := ParseRenames(specs)
+	if err != nil {
+		return err
+	}
+	table.renames = renames
+	return nil
+}
+
+// SetNormalizeHeaders converts column names to snake_case names.
+func (table *Table) SetNormalizeHeaders(normalize bool) {
+	table.normalize = normalize
+}
+
+// Warnings returns the problems found while loading that did not stop it.
+func (table *Table) Warnings() ([]string) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.warningList()
+}
+
+// warningList is called with the lock held.
+func (table *Table) warningList() ([]string) {
+	warnings := append([]string{}, table.warnings...)
+	if table.expr_errors>0 {
+		warnings = append(warnings, fmt.Sprintf("expression failed on %v rows, which were dropped: %v",
+			table.expr_errors, table.expr_error))
+	}
+	for _,d := range(table.derived) {
+		if d.errors>0 {
+			warnings = append(warnings, fmt.Sprintf("derived column %v failed on %v rows, which were left empty: %v",
+				d.name, d.errors, d.err))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,90 @@
+package tabulon
+
+import (
+	"testing"
+	"reflect"
+	"strings"
+)
+
+func Test_normalizeName(t *testing.T) {
+	cases := map[string]string{
+		"Unit Price ($)": "unit_price",
+		"  id ": "id",
+		"orderDate": "order_date",
+		"HTTPStatus": "httpstatus",
+		"Line2Total": "line2_total",
+		"already_snake": "already_snake",
+		"%": "",
+	}
+	for name,expected := range(cases) {
+		if got := NormalizeName(name); got!=expected {
+			t.Errorf("%q: got %q, expected %q", name, got, expected)
+		}
+	}
+}
+
+func Test_uniqueNames(t *testing.T) {
+	names := uniqueNames([]string{"id", "id", "id_2", "id", "name"})
+	expected := []string{"id", "id_3", "id_2", "id_4", "name"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("got %v, expected %v", names, expected)
+	}
+}
+
+func Test_renameHeader(t *testing.T) {
+	renames, err := ParseRenames([]string{"Unit Price ($)=price", "qty=quantity"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewCSVReader()
+	reader.SetRenames(renames)
+	reader.SetNormalizeHeaders(true)
+	reader.SetColumns([]string{"price,quantity,total"})
+	reader.ParseLine(`Unit Price ($),Qty,Total,%`)
+	expected := []string{"price", "quantity", "total"}
+	if !reflect.DeepEqual(reader.GetHeader(), expected) {
+		t.Errorf("got %v, expected %v", reader.GetHeader(), expected)
+	}
+
+	if _, err := ParseRenames([]string{"missing"}); err == nil {
+		t.Error("rename without = accepted")
+	}
+}
+
+func Test_duplicateHeader(t *testing.T) {
+	reader := NewCSVReader()
+	reader.SetFileName("dup.csv")
+	reader.SetColumns([]string{"id_2"})
+	reader.ParseLine("id,name,id")
+	if !reflect.DeepEqual(reader.GetHeader(), []string{"id_2"}) {
+		t.Errorf("duplicate column not addressable: %v", reader.GetHeader())
+	}
+
+	row := reader.ParseLine("1,a,2")
+	if !reflect.DeepEqual(row, []string{"2"}) {
+		t.Errorf("incorrect row %v", row)
+	}
+
+	expected := []string{"dup.csv:1: duplicate column id renamed to id_2"}
+	if !reflect.DeepEqual(reader.Warnings(), expected) {
+		t.Errorf("got warnings %v", reader.Warnings())
+	}
+}
+
+func Test_duplicateExpr(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("id_2 > id"); err != nil {
+		t.Fatal(err)
+	}
+	table.SetDelimiter(',')
+	if err := table.processFile(strings.NewReader("id,id\n1,2\n3,1\n"), "dup.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.content)!=1 || table.content[0][0]!="1" {
+		t.Errorf("expression did not see both columns: %v", table.content)
+	}
+	if len(table.Warnings())!=1 {
+		t.Errorf("expected one warning, got %v", table.Warnings())
+	}
+}
@@ -0,0 +1,140 @@
+package tabulon
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func (table *Table) SetRecursive(recursive bool) {
+	table.recursive = recursive
+}
+
+func checkPatterns(patterns []string) (error) {
+	for _,p := range(patterns) {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return errors.New("invalid pattern: " + p)
+		}
+	}
+	return nil
+}
+
+// SetInclude limits the files found in directories and globs to those whose
+// base name matches one of the patterns.
+func (table *Table) SetInclude(patterns []string) (error) {
+	if err := checkPatterns(patterns); err != nil {
+		return err
+	}
+	table.include = patterns
+	return nil
+}
+
+// SetExclude skips files found in directories and globs whose base name
+// matches one of the patterns.
+func (table *Table) SetExclude(patterns []string) (error) {
+	if err := checkPatterns(patterns); err != nil {
+		return err
+	}
+	table.exclude = patterns
+	return nil
+}
+
+func matchAny(name string, patterns []string) (bool) {
+	for _,p := range(patterns) {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (table *Table) wantFile(name string) (bool) {
+	name = filepath.Base(name)
+	if len(table.include)>0 && !matchAny(name, table.include) {
+		return false
+	}
+	return !matchAny(name, table.exclude)
+}
+
+// listDirectory returns the files in a directory in lexical order, descending
+// into subdirectories if recursive is set.
+func (table *Table) listDirectory(dir string) ([]string, error) {
+	var files []string
+	if table.recursive {
+		err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) (error) {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() && table.wantFile(file) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		return files, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _,fi := range(entries) {
+		file := filepath.Join(dir, fi.Name())
+		if !fi.IsDir() && table.wantFile(file) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// expandInputs turns the input arguments into a list of files. Directories
+// are listed and glob patterns which do not name an existing file are
+// expanded; both in lexical order and subject to the include and exclude
+// patterns. Files named explicitly are always kept.
+func (table *Table) expandInputs(args []string) ([]string, error) {
+	var files []string
+	for _,arg := range(args) {
+		fi, err := os.Stat(arg)
+		if err != nil && strings.ContainsAny(arg, "*?[") {
+			matches, gerr := filepath.Glob(arg)
+			if gerr != nil || len(matches)==0 {
+				return nil, newFileError(arg, errors.New("no files match pattern"))
+			}
+
+			sort.Strings(matches)
+			for _,m := range(matches) {
+				if mi, err := os.Stat(m); err == nil && mi.IsDir() {
+					found, err := table.listDirectory(m)
+					if err != nil {
+						return nil, newFileError(m, err)
+					}
+					files = append(files, found...)
+				} else if table.wantFile(m) {
+					files = append(files, m)
+				}
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, newFileError(arg, err)
+		}
+
+		if fi.IsDir() {
+			found, err := table.listDirectory(arg)
+			if err != nil {
+				return nil, newFileError(arg, err)
+			}
+			files = append(files, found...)
+		} else {
+			files = append(files, arg)
+		}
+	}
+
+	if len(files)==0 {
+		return nil, errors.New("no input files found")
+	}
+	return files, nil
+}
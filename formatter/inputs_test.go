@@ -0,0 +1,66 @@
+package tabulon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_expandInputs(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "2022", "01"), 0755)
+	os.MkdirAll(filepath.Join(dir, "2021"), 0755)
+	for _,f := range([]string{"top.csv", "2022/b.csv", "2022/a.csv", "2022/01/c.csv", "2022/notes.txt", "2021/z.csv"}) {
+		ioutil.WriteFile(filepath.Join(dir, f), []byte("id\n" + f + "\n"), 0644)
+	}
+	rel := func(files []string) ([]string) {
+		for i,f := range(files) {
+			files[i], _ = filepath.Rel(dir, f)
+		}
+		return files
+	}
+
+	table := NewTable()
+	files, err := table.expandInputs([]string{filepath.Join(dir, "2022")})
+	expected := []string{"2022/a.csv", "2022/b.csv", "2022/notes.txt"}
+	if err != nil || !reflect.DeepEqual(rel(files), expected) {
+		t.Error("directory listing incorrect: ", files, err)
+	}
+
+	table.SetRecursive(true)
+	table.SetInclude([]string{"*.csv"})
+	table.SetExclude([]string{"b.*"})
+	files, err = table.expandInputs([]string{dir})
+	expected = []string{"2021/z.csv", "2022/01/c.csv", "2022/a.csv", "top.csv"}
+	if err != nil || !reflect.DeepEqual(rel(files), expected) {
+		t.Error("recursive listing incorrect: ", files, err)
+	}
+
+	table = NewTable()
+	files, err = table.expandInputs([]string{filepath.Join(dir, "20*", "*.csv"), filepath.Join(dir, "2022", "notes.txt")})
+	expected = []string{"2021/z.csv", "2022/a.csv", "2022/b.csv", "2022/notes.txt"}
+	if err != nil || !reflect.DeepEqual(rel(files), expected) {
+		t.Error("glob expansion incorrect: ", files, err)
+	}
+
+	if _, err = table.expandInputs([]string{filepath.Join(dir, "*.psv")}); err == nil {
+		t.Error("glob without matches should fail")
+	}
+	if err := table.SetInclude([]string{"[a-"}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+	if _, err = table.expandInputs([]string{filepath.Join(dir, "missing.csv")}); err == nil {
+		t.Error("missing file should fail")
+	}
+
+	table = NewTable()
+	table.SetRecursive(true)
+	if err := table.ReadFiles([]string{filepath.Join(dir, "2022")}); err != nil {
+		t.Fatal(err)
+	}
+	if table.nrows != 4 || table.content[0][0] != "2022/01/c.csv" {
+		t.Error("recursive directory load incorrect: ", table.content)
+	}
+}
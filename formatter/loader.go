@@ -0,0 +1,83 @@
+package tabulon
+
+import (
+	"io"
+	"time"
+)
+
+const notify_interval = 100*time.Millisecond
+
+// countingReader tracks how much of the raw input has been consumed, for
+// reporting load progress.
+type countingReader struct {
+	r io.Reader
+	table *Table
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.table.mu.Lock()
+	c.table.bytes_read += int64(n)
+	c.table.mu.Unlock()
+	return n, err
+}
+
+func (table *Table) IsLoading() (bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.loading
+}
+
+// Progress returns the fraction of input read so far, or -1 if the input
+// size is not known.
+func (table *Table) Progress() (float64) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	if table.bytes_total<=0 {
+		return -1
+	}
+	return float64(table.bytes_read) / float64(table.bytes_total)
+}
+
+// rowAdded is called with the lock held after each row is appended and
+// calls the notify callback at most once per notify_interval.
+func (table *Table) rowAdded(row []string) {
+	table.nrows = len(table.content)
+	for len(table.limits)<len(row) {
+		table.limits = append(table.limits, 0)
+	}
+	for j,cell := range(row) {
+		table.limits[j] = int_max(table.limits[j], len(cell))
+	}
+
+	if table.notify!=nil && time.Since(table.notified)>=notify_interval {
+		table.notified = time.Now()
+		go table.notify()
+	}
+}
+
+// LoadInBackground runs load in a goroutine while the table is displayed,
+// calling notify as rows arrive and done with the result of load. A sort
+// applied while loading is repeated if more rows arrived after it.
+func (table *Table) LoadInBackground(load func() (error), notify func(), done func(error)) {
+	table.mu.Lock()
+	table.loading = true
+	table.notify = notify
+	table.mu.Unlock()
+
+	go func() {
+		err := load()
+
+		table.mu.Lock()
+		table.loading = false
+		table.notify = nil
+		idx, rev := table.sort_idx, table.sort_rev
+		resort := idx>=0 && table.sorted_rows!=len(table.content)
+		table.mu.Unlock()
+
+		if err==nil && resort {
+			table.performSort(idx, rev)
+		}
+		done(err)
+	}()
+}
@@ -0,0 +1,54 @@
+package tabulon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_loadInBackground(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("id,name\n")
+	for i:=0; i<20000; i++ {
+		fmt.Fprintf(&input, "%v,name%v\n", i, i)
+	}
+
+	fname := filepath.Join(t.TempDir(), "big.csv")
+	if err := ioutil.WriteFile(fname, []byte(input.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := NewTable()
+	done := make(chan error)
+	table.LoadInBackground(
+		func() (error) {
+			return table.ReadFiles([]string{fname})
+		},
+		func() {},
+		func(err error) {
+			done <- err
+		})
+
+	// navigate, search and sort while rows are still arriving
+	for table.IsLoading() {
+		table.Rows()
+		table.Progress()
+		table.Search(0, "name1")
+		table.SortByIndexReverse(0)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if table.Rows() != 20000 {
+		t.Fatal("background load should read all rows, got ", table.Rows())
+	}
+	if table.Progress() != 1 {
+		t.Error("progress should be complete: ", table.Progress())
+	}
+	if table.content[0][0] != "19999" || table.content[19999][0] != "0" {
+		t.Error("sort during load should be reapplied: ", table.content[0], table.content[19999])
+	}
+}
@@ -0,0 +1,162 @@
+package tabulon
+
+import (
+	"errors"
+	"strings"
+)
+
+// MergeMode decides how the headers of several input files are reconciled.
+// Columns are always matched by name; the modes differ in which columns are
+// kept when the files disagree.
+type MergeMode int
+const (
+	MergeUnion MergeMode = iota
+	MergeIntersect
+	MergeStrict
+)
+
+func ParseMergeMode(mode string) (MergeMode, bool) {
+	switch strings.ToLower(mode) {
+	case "", "union":
+		return MergeUnion, true
+	case "intersect":
+		return MergeIntersect, true
+	case "strict":
+		return MergeStrict, true
+	}
+	return MergeUnion, false
+}
+
+func (table *Table) SetMerge(mode string) (error) {
+	m, ok := ParseMergeMode(mode)
+	if !ok {
+		return errors.New("unsupported merge mode: " + mode)
+	}
+	table.merge = m
+	return nil
+}
+
+func sameColumns(a []string, b []string) (bool) {
+	if len(a)!=len(b) {
+		return false
+	}
+	for _,col := range(a) {
+		if findToken(col, b)==-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// dropColumns removes the columns not in keep from the header and from every
+// row loaded so far; called with the lock held.
+func (table *Table) dropColumns(keep []string) {
+	var idx []int
+	var header []string
+	for i,col := range(table.header) {
+		if findToken(col, keep)!=-1 {
+			idx = append(idx, i)
+			header = append(header, col)
+		}
+	}
+
+	for r,row := range(table.content) {
+		out := make([]string, len(idx))
+		for j,i := range(idx) {
+			out[j] = row[i]
+		}
+		table.content[r] = out
+	}
+	table.header = header
+}
+
+func insertCells(row []string, pos int, cells []string) ([]string) {
+	out := make([]string, 0, len(row)+len(cells))
+	out = append(out, row[:pos]...)
+	out = append(out, cells...)
+	return append(out, row[pos:]...)
+}
+
+// addColumns adds new columns to the header, ahead of any provenance and
+// derived columns, and pads every row loaded so far; called with the lock
+// held.
+func (table *Table) addColumns(cols []string) {
+	if len(cols)==0 {
+		return
+	}
+
+	pos := len(table.header)
+	for pos>0 && (table.header[pos-1]==filename_column || table.header[pos-1]==lineno_column ||
+		table.isDerived(table.header[pos-1])) {
+		pos--
+	}
+
+	table.header = insertCells(table.header, pos, cols)
+	empty := make([]string, len(cols))
+	for r,row := range(table.content) {
+		table.content[r] = insertCells(row, pos, empty)
+	}
+}
+
+// mergeHeader reconciles the header of the current file with the table
+// header and records where each of its columns goes.
+func (table *Table) mergeHeader(header []string) (error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+
+	table.file_mapped = true
+	table.file_map = nil
+	if table.header==nil {
+		table.header = header
+		table.ncols = len(header)
+		return nil
+	}
+
+	if strings.Join(header, "\x00")==strings.Join(table.header, "\x00") {
+		return nil
+	}
+
+	switch table.merge {
+	case MergeStrict:
+		if !sameColumns(header, table.header) {
+			return &ParseError{File: table.fname,
+				Reason: "header differs from first file: " + strings.Join(header, ",")}
+		}
+
+	case MergeIntersect:
+		table.dropColumns(header)
+
+	case MergeUnion:
+		var extra []string
+		for _,col := range(header) {
+			if findToken(col, table.header)==-1 {
+				extra = append(extra, col)
+			}
+		}
+		table.addColumns(extra)
+	}
+
+	table.file_map = make([]int, len(header))
+	for i,col := range(header) {
+		table.file_map[i] = findToken(col, table.header)
+	}
+	table.ncols = len(table.header)
+	table.resetLimits()
+	return nil
+}
+
+// mapRow moves the cells of a row from the current file into table column
+// order; the row is unchanged if the file header matches the table header.
+func (table *Table) mapRow(row []string) ([]string) {
+	if table.file_map==nil {
+		return row
+	}
+
+	out := make([]string, table.ncols)
+	for i,j := range(table.file_map) {
+		if j>=0 && i<len(row) {
+			out[j] = row[i]
+		}
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package tabulon
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadFiles(table *Table, files ...string) (error) {
+	table.SetDelimiter(',')
+	for i,f := range(files) {
+		if err := table.processFile(strings.NewReader(f), "file" + string(rune('1'+i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func Test_mergeUnion(t *testing.T) {
+	table := NewTable()
+	err := loadFiles(&table, "id,name\n1,a\n", "name,id,extra\nb,2,x\n", "id\n3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"id", "name", "extra"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Error("union header incorrect: ", table.header)
+	}
+	content := [][]string{{"1", "a", ""}, {"2", "b", "x"}, {"3", "", ""}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Error("union content incorrect: ", table.content)
+	}
+}
+
+func Test_mergeIntersect(t *testing.T) {
+	table := NewTable()
+	table.SetMerge("intersect")
+	err := loadFiles(&table, "id,name,extra\n1,a,x\n", "name,id\nb,2\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"id", "name"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Error("intersect header incorrect: ", table.header)
+	}
+	content := [][]string{{"1", "a"}, {"2", "b"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Error("intersect content incorrect: ", table.content)
+	}
+	if len(table.limits) != 2 {
+		t.Error("limits not updated after dropping columns: ", table.limits)
+	}
+}
+
+func Test_mergeStrict(t *testing.T) {
+	table := NewTable()
+	table.SetMerge("strict")
+	err := loadFiles(&table, "id,name\n1,a\n", "name,id\nb,2\n")
+	if err != nil {
+		t.Fatal("reordered columns should be accepted: ", err)
+	}
+	content := [][]string{{"1", "a"}, {"2", "b"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Error("strict content incorrect: ", table.content)
+	}
+
+	table = NewTable()
+	table.SetMerge("strict")
+	err = loadFiles(&table, "id,name\n1,a\n", "id,other\n2,b\n")
+	if err == nil || !strings.HasPrefix(err.Error(), "file2: header differs") {
+		t.Error("differing headers should fail in strict mode: ", err)
+	}
+	if err := table.SetMerge("append"); err == nil {
+		t.Error("unsupported merge mode accepted")
+	}
+}
+
+func Test_mergeProvenance(t *testing.T) {
+	table := NewTable()
+	table.SetProvenance(true, false)
+	err := loadFiles(&table, "a\n1\n", "a,b\n2,3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a", "b", "_file"}
+	if !reflect.DeepEqual(table.header, expected) {
+		t.Error("provenance columns should stay last: ", table.header)
+	}
+	content := [][]string{{"1", "", "file1"}, {"2", "3", "file2"}}
+	if !reflect.DeepEqual(table.content, content) {
+		t.Error("merged provenance content incorrect: ", table.content)
+	}
+}
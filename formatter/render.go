@@ -2,9 +2,11 @@ package tabulon
 
 import (
 	"os"
-	"strings"
+	"io"
+	"encoding/csv"
 	"log"
 	"fmt"
+	"github.com/gdamore/tcell/v2"
 )
 
 func (table *Table) RenderPlaintext() {
@@ -19,14 +21,39 @@ func (table *Table) RenderInteractive() {
 	term.Run(table)
 }
 
+// RenderInteractiveWhileLoading starts the interactive viewer straight away
+// and shows rows as load adds them; if load fails the viewer exits with
+// its error.
+func (table *Table) RenderInteractiveWhileLoading(load func() (error)) {
+	term := NewTerminal()
+	table.LoadInBackground(load,
+		func() {
+			term.screen.PostEvent(tcell.NewEventInterrupt(nil))
+		},
+		func(err error) {
+			term.post(tcell.NewEventInterrupt(loadResult{err}))
+		})
+	term.Run(table)
+}
+
 func (table *Table) RenderCSV() {
-	fd := os.Stdout
-	d := string(table.output_delimiter)
-	fd.Write([]byte(strings.Join(table.header, d) + "\n"))
+	if err := table.writeCSV(os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+	os.Stdout.Close()
+}
+
+// writeCSV writes the table quoting cells that contain the delimiter, quotes
+// or line breaks, so that the output reads back unchanged.
+func (table *Table) writeCSV(fd io.Writer) (error) {
+	w := csv.NewWriter(fd)
+	w.Comma = table.output_delimiter
+	w.Write(table.header)
 	for _,row := range(table.content) {
-		fd.Write([]byte(strings.Join(row, d) + "\n"))
+		w.Write(row)
 	}
-	fd.Close()
+	w.Flush()
+	return w.Error()
 }
 
 func (table *Table) RenderList(col string) {
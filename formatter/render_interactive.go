@@ -5,6 +5,7 @@ import (
 	"os"
 	"fmt"
 	"strconv"
+	"time"
 	"github.com/gdamore/tcell/v2"
 )
 
@@ -68,23 +69,30 @@ func tcell_row(s tcell.Screen, x, y int, xstart int, row []string, lim []int, st
 			xpos := x+k
 			s.SetContent(xpos, y, r, nil, style)
 		}
-		x += (lim[i] + 1)
+		if i<len(lim) {
+			x += lim[i]
+		}
+		x++
 	}
 }
 
 func tcell_render(term *Terminal, table *Table) {
+	loading := table.IsLoading()
+	progress := table.Progress()
+	table.mu.RLock()
+	defer table.mu.RUnlock()
 	term.screen.Clear()
 
 	xlim_hi := table.ncols-1
 	ylim_hi := table.nrows-1
-	term.yview = int_max(0, term.yview)
-	term.xview = int_max(0, term.xview)
 	if term.yview>ylim_hi {
 		term.yview = ylim_hi
 	}
 	if term.xview>xlim_hi {
 		term.xview = xlim_hi
 	}
+	term.yview = int_max(0, term.yview)
+	term.xview = int_max(0, term.xview)
 
 	y_header := 0
 	y_status := term.yscreen-1
@@ -109,13 +117,26 @@ func tcell_render(term *Terminal, table *Table) {
 	}
 
 	if(term.mode == Normal) {
-		tcell_line(term.screen, 0, y_status,
-			fmt.Sprintf("%v: row=%v/%v col=%v/%v screen=%v,%v",
-				table.description,
-				term.yview, table.nrows,
-				term.xview, table.ncols,
-				term.yscreen, term.xscreen),
-			term.style_underl)
+		status := fmt.Sprintf("%v: row=%v/%v col=%v/%v screen=%v,%v",
+			table.description,
+			term.yview, table.nrows,
+			term.xview, table.ncols,
+			term.yscreen, term.xscreen)
+		if source := table.rowSource(term.yview); len(source)>0 {
+			status += " source=" + source
+		}
+		if table.nbad>0 {
+			status += fmt.Sprintf(" rejected=%v", table.nbad)
+		}
+		if warnings := table.warningList(); len(warnings)>0 {
+			status += fmt.Sprintf(" warnings=%v", len(warnings))
+		}
+		if loading && progress>=0 {
+			status += fmt.Sprintf(" loading %.0f%%", 100*progress)
+		} else if loading {
+			status += " loading..."
+		}
+		tcell_line(term.screen, 0, y_status, status, term.style_underl)
 
 	} else if(term.mode == Search) {
 		tcell_line(term.screen, 0, y_status, fmt.Sprintf("Search: %v", term.search),
@@ -173,7 +194,7 @@ func run_normal(ev *tcell.EventKey, term *Terminal, table *Table) {
 	}
 
 	if ev.Key() == tcell.KeyEnd || ev.Rune()=='G' {
-		term.yview = table.nrows - term.yscreen
+		term.yview = table.Rows() - term.yscreen
 	}
 
 	if ev.Rune() == '/' {
@@ -246,6 +267,17 @@ func run_help(ev *tcell.EventKey, term *Terminal, table *Table) {
 	}
 }
 
+type loadResult struct {
+	err error
+}
+
+// post delivers an event to the event loop, retrying while the queue is full.
+func (term *Terminal) post(ev tcell.Event) {
+	for term.screen.PostEvent(ev) != nil {
+		time.Sleep(notify_interval)
+	}
+}
+
 func (term *Terminal) Run(table *Table) {
 	for {
 		tcell_render(term, table)
@@ -254,6 +286,13 @@ func (term *Terminal) Run(table *Table) {
 			term.screen.Sync()
 			term.xscreen, term.yscreen = term.screen.Size()
 
+		case *tcell.EventInterrupt:
+			if result, ok := ev.Data().(loadResult); ok && result.err != nil {
+				term.screen.Fini()
+				fmt.Fprintln(os.Stderr, result.err)
+				os.Exit(1)
+			}
+
 		case *tcell.EventKey:
 			if(term.mode == Normal) {
 				run_normal(ev, term, table)
@@ -0,0 +1,31 @@
+package tabulon
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_writeCSV(t *testing.T) {
+	table := NewTable()
+	loadString(t, &table, "id,text\n1,\"two\nlines\"\n2,\"He said \"\"hi\"\"\"\n3,\"a,b\"\n")
+
+	var out bytes.Buffer
+	if err := table.writeCSV(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	reread := NewTable()
+	loadString(t, &reread, out.String())
+	if !reflect.DeepEqual(reread.header, table.header) || !reflect.DeepEqual(reread.content, table.content) {
+		t.Errorf("output does not read back:\n%v", out.String())
+	}
+
+	table.SetOutputDelimiter(';')
+	out.Reset()
+	table.writeCSV(&out)
+	if !strings.Contains(out.String(), "3;a,b\n") {
+		t.Errorf("output delimiter ignored:\n%v", out.String())
+	}
+}
@@ -0,0 +1,210 @@
+package tabulon
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	sniff_lines = 100
+	sniff_bytes = 64*1024
+)
+
+var sniff_candidates = []rune{',', '\t', '|', ';', ':', ' '}
+
+type Dialect struct {
+	delimiter rune
+	quote rune
+	escape rune
+	fields int
+	widths []int
+	preamble int
+	source string
+	fname string
+}
+
+func (d Dialect) String() (string) {
+	var s string
+	if len(d.fname)>0 {
+		s = d.fname + ": "
+	}
+
+	if d.widths!=nil {
+		w := make([]string, len(d.widths))
+		for i,n := range(d.widths) {
+			w[i] = strconv.Itoa(n)
+		}
+		return s + "widths=" + strings.Join(w, ",") + " (" + d.source + ")"
+	}
+
+	s += fmt.Sprintf("delimiter=%v quote=%v", strconv.QuoteRune(d.delimiter), strconv.QuoteRune(d.quote))
+	if d.escape!=0 {
+		s += fmt.Sprintf(" escape=%v", strconv.QuoteRune(d.escape))
+	}
+	if d.fields>0 {
+		s += fmt.Sprintf(" fields=%v", d.fields)
+	}
+	if d.preamble>0 {
+		s += fmt.Sprintf(" preamble=%v", d.preamble)
+	}
+	return s + " (" + d.source + ")"
+}
+
+// sampleLines returns up to n complete lines from the start of the input
+// without consuming them, after dropping the first skip lines.
+func sampleLines(br *bufio.Reader, skip int, n int) ([]string) {
+	buf, err := br.Peek(sniff_bytes)
+	lines := strings.Split(string(buf), "\n")
+	if err==nil && len(lines)>1 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines)>0 && lines[len(lines)-1]=="" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if skip>=len(lines) {
+		return nil
+	}
+	lines = lines[skip:]
+	if len(lines)>n {
+		lines = lines[:n]
+	}
+	return lines
+}
+
+func countFields(lines []string, delimiter rune, quote rune, escape rune) ([]int) {
+	reader := NewCSVReader()
+	reader.SetDelimiter(delimiter)
+	reader.SetQuote(quote)
+	reader.SetEscape(escape)
+
+	var counts []int
+	for _,line := range(lines) {
+		if reader.tokenize(strings.TrimSuffix(line, "\r")) {
+			counts = append(counts, len(reader.record))
+			reader.record = nil
+		}
+	}
+	return counts
+}
+
+// scoreFields returns the most common field count and the fraction of
+// records which have it.
+func scoreFields(counts []int) (int, float64) {
+	freq := make(map[int]int)
+	mode := 0
+	for _,n := range(counts) {
+		freq[n]++
+		if freq[n]>freq[mode] || (freq[n]==freq[mode] && n>mode) {
+			mode = n
+		}
+	}
+
+	if len(counts)==0 {
+		return 0, 0
+	}
+	return mode, float64(freq[mode]) / float64(len(counts))
+}
+
+// SniffDialect picks the candidate delimiter which splits the sample into
+// the most consistent number of fields. Candidates are tried in order, so an
+// earlier candidate wins ties. It returns false if no candidate splits the
+// sample into more than one field.
+func SniffDialect(lines []string, candidates []rune, quote rune, escape rune) (Dialect, bool) {
+	best := Dialect{delimiter: 0, quote: quote, escape: escape, source: "sniffed"}
+	best_score := 0.0
+	for _,d := range(candidates) {
+		fields, score := scoreFields(countFields(lines, d, quote, escape))
+		if fields<2 || score<=best_score {
+			continue
+		}
+
+		best.delimiter = d
+		best.fields = fields
+		best_score = score
+	}
+
+	return best, best.delimiter!=0
+}
+
+func (table *Table) detectDialect(br *bufio.Reader, fname string) (Dialect) {
+	d := Dialect{delimiter: table.delimiter, quote: table.quote, escape: table.escape, fname: fname}
+	if table.fixed && table.widths!=nil {
+		d.widths = table.widths
+		d.source = "option"
+		return d
+	}
+
+	if table.fixed {
+		d.widths = InferWidths(sampleLines(br, table.skip, sniff_lines))
+		d.source = "inferred"
+		return d
+	}
+
+	if table.delimiter!=0 {
+		d.source = "option"
+		return d
+	}
+
+	guess := guessDelimiter(fname)
+	candidates := append([]rune{guess}, sniff_candidates...)
+	var lines []string
+	reader := NewCSVReader()
+	reader.SetComment(table.comment)
+	reader.SetSkipBlank(true)
+	for _,line := range(sampleLines(br, table.skip, sniff_lines)) {
+		if !reader.ignoreLine(strings.TrimSuffix(line, "\r")) {
+			lines = append(lines, line)
+		}
+	}
+	if sniffed, ok := SniffDialect(lines, candidates, table.quote, table.escape); ok {
+		sniffed.fname = fname
+		return sniffed
+	}
+
+	d.delimiter = guess
+	d.source = "default"
+	return d
+}
+
+// detectPreamble returns the number of sample lines before the header row,
+// taken to be the first record whose field count matches both the most
+// common field count and the record after it. Blank lines are not records
+// here, even without --skip-blank, so that a blank line after the header
+// does not hide it.
+func (table *Table) detectPreamble(lines []string, d Dialect) (int) {
+	reader := NewCSVReader()
+	reader.SetDelimiter(d.delimiter)
+	reader.SetQuote(d.quote)
+	reader.SetEscape(d.escape)
+	reader.SetComment(table.comment)
+	reader.SetSkipBlank(true)
+
+	var counts []int
+	var starts []int
+	start := 0
+	for i,line := range(lines) {
+		line = strings.TrimSuffix(line, "\r")
+		if reader.ignoreLine(line) {
+			continue
+		}
+		if !reader.in_quote {
+			start = i
+		}
+		if reader.tokenize(line) {
+			counts = append(counts, len(reader.record))
+			starts = append(starts, start)
+			reader.record = nil
+		}
+	}
+
+	mode, _ := scoreFields(counts)
+	for i,n := range(counts) {
+		if n==mode && (i+1==len(counts) || counts[i+1]==mode) {
+			return starts[i]
+		}
+	}
+	return 0
+}
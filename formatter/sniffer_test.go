@@ -0,0 +1,105 @@
+package tabulon
+
+import (
+	"bufio"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_sniffDialect(t *testing.T) {
+	lines := []string{"a;b;c", "1;2,5;3", "4;5;6"}
+	d, ok := SniffDialect(lines, sniff_candidates, '"', 0)
+	if !ok || d.delimiter != ';' || d.fields != 3 {
+		t.Error("semicolon not detected: ", d)
+	}
+
+	lines = []string{"name\tnote", "x\t\"a\tb\"", "y\tc"}
+	d, ok = SniffDialect(lines, sniff_candidates, '"', 0)
+	if !ok || d.delimiter != '\t' || d.fields != 2 {
+		t.Error("tab not detected: ", d)
+	}
+
+	lines = []string{`"a,b",c`, `"d,e",f`}
+	d, ok = SniffDialect(lines, []rune{'|', ','}, '"', 0)
+	if !ok || d.delimiter != ',' || d.fields != 2 {
+		t.Error("quoted delimiters should be ignored: ", d)
+	}
+
+	lines = []string{"a|b,c", "d|e,f"}
+	d, _ = SniffDialect(lines, []rune{'|', ','}, '"', 0)
+	if d.delimiter != '|' {
+		t.Error("earlier candidate should win ties: ", d)
+	}
+
+	lines = []string{"single", "column"}
+	_, ok = SniffDialect(lines, sniff_candidates, '"', 0)
+	if ok {
+		t.Error("single column input should not sniff a delimiter")
+	}
+}
+
+func Test_detectDialect(t *testing.T) {
+	table := NewTable()
+	br := bufio.NewReader(strings.NewReader("junk line\nx:y:z\n1:2:3\n"))
+	table.SetSkip(1)
+	d := table.detectDialect(br, "")
+	if d.delimiter != ':' || d.source != "sniffed" {
+		t.Error("colon not detected after skip: ", d)
+	}
+
+	br = bufio.NewReader(strings.NewReader("single\ncolumn\n"))
+	d = table.detectDialect(br, "data.tsv")
+	if d.delimiter != '\t' || d.source != "default" {
+		t.Error("filename should decide when sniffing fails: ", d)
+	}
+
+	table.SetDelimiter('|')
+	d = table.detectDialect(br, "data.tsv")
+	if d.delimiter != '|' || d.source != "option" {
+		t.Error("explicit delimiter should win: ", d)
+	}
+}
+
+func Test_perFileDialect(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.tsv"), filepath.Join(dir, "c.txt")}
+	ioutil.WriteFile(files[0], []byte("id,name\n1,x y\n"), 0644)
+	ioutil.WriteFile(files[1], []byte("id\tname\n2\tp,q\n"), 0644)
+	ioutil.WriteFile(files[2], []byte("name|id\nz|3\n"), 0644)
+
+	table := NewTable()
+	if err := table.ReadFiles(files); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{{"1", "x y"}, {"2", "p,q"}, {"3", "z"}}
+	if !reflect.DeepEqual(table.content, expected) {
+		t.Error("mixed format content incorrect: ", table.content)
+	}
+
+	dialects := table.GetDialects()
+	if len(dialects) != 3 || dialects[0].delimiter != ',' || dialects[1].delimiter != '\t' || dialects[2].delimiter != '|' {
+		t.Error("per-file dialects incorrect: ", dialects)
+	}
+	if table.output_delimiter != ',' {
+		t.Error("output delimiter should not follow input: ", table.output_delimiter)
+	}
+}
+
+func Test_sniffFiles(t *testing.T) {
+	table := NewTable()
+	table.SetStrict(true)
+	dialects, err := table.SniffFiles([]string{"../testdata/gics.psv", "../testdata/diamonds.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dialects)!=2 || dialects[0].delimiter!='|' || dialects[1].delimiter!=',' || dialects[1].fields!=10 {
+		t.Errorf("incorrect dialects %v", dialects)
+	}
+	if !table.IsEmpty() || len(table.GetDialects())!=0 {
+		t.Error("sniffing loaded rows")
+	}
+}
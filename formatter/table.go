@@ -2,21 +2,36 @@ package tabulon
 
 import (
 	"os"
+	"io"
+	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"bufio"
 	"path"
 	"sort"
-	"strconv"
-	"github.com/danielgtaylor/mexpr"
+	"sync"
+	"time"
 )
 
 type Table struct {
 	header []string
 	content [][]string
-	match []string
-	remove []string
+	filter *filterNode
+	any []string
+	match_text []string
+	remove_text []string
+	match_regex []string
+	remove_regex []string
+	ignore_case bool
 	delimiter rune
+	dialects []Dialect
+	file_dialect Dialect
+	quote rune
+	escape rune
+	fixed bool
+	widths []int
+	encoding string
 	output_delimiter rune
 	nrows int
 	ncols int
@@ -25,14 +40,56 @@ type Table struct {
 	skip int
 	head int
 	tail int
+	row_start int
+	row_end int
+	per_file bool
+	window *rowWindow
 	limit int
 	columns []string
-	match_parser mexpr.Interpreter
+	no_header bool
+	header_names []string
+	with_filename bool
+	with_lineno bool
+	comment string
+	skip_blank bool
+	detect_header bool
+	renames map[string]string
+	normalize bool
+	recursive bool
+	include []string
+	exclude []string
+	strict bool
+	lenient bool
+	bad_rows io.Writer
+	nbad int
+	warnings []string
+	types map[string]ColumnType
+	type_overrides map[string]ColumnType
+	merge MergeMode
+	fname string
+	file_mapped bool
+	file_map []int
+	match_expr *expression
+	expr_check bool
+	derived []*derivedColumn
+	expr_errors int
+	expr_error string
+	mu *sync.RWMutex
+	loading bool
+	bytes_read int64
+	bytes_total int64
+	notify func()
+	notified time.Time
+	sort_idx int
+	sort_rev bool
+	sorted_rows int
 }
 
 func NewTable() (Table) {
 	t := Table {
 		delimiter: 0,
+		quote: '"',
+		escape: 0,
 		header: nil,
 		content: nil,
 		nrows: 0,
@@ -41,43 +98,105 @@ func NewTable() (Table) {
 		skip: 0,
 		head: -1,
 		tail: -1,
+		row_start: 1,
+		row_end: -1,
 		limit: 0,
 		columns: nil,
-		match_parser: nil,
+		mu: &sync.RWMutex{},
+		sort_idx: -1,
 	}
 
 	return t
 }
 
 func (table *Table) IsEmpty() bool {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
 	return len(table.content) == 0
 }
 
-func (table *Table) SetMatch(m []string) {
-	table.match = m
-}
-
-func (table *Table) SetRemove(m []string) {
-	table.remove = m
+func (table *Table) Rows() (int) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.nrows
 }
 
 func (table *Table) SetSkip(skip int) {
 	table.skip = skip
 }
 
+func (table *Table) SetComment(comment string) {
+	table.comment = comment
+}
+
+func (table *Table) SetSkipBlank(skip_blank bool) {
+	table.skip_blank = skip_blank
+}
+
+// SetDetectHeader skips any preamble before the header row of each file; see
+// detectPreamble.
+func (table *Table) SetDetectHeader(detect_header bool) {
+	table.detect_header = detect_header
+}
+
 func (table *Table) SetLimit(limit int) {
 	table.limit = limit
 }
 
-func (table *Table) SetMatchExpr(match_expr string) {
-	l := mexpr.NewLexer(match_expr)
-	p := mexpr.NewParser(l)
-	ast, err := p.Parse()
+func (table *Table) SetMatchExpr(match_expr string) (error) {
+	e, err := compileExpr(match_expr)
 	if err != nil {
-		log.Fatal("filterRow: invalid expression: ", match_expr)
+		return err
+	}
+
+	table.match_expr = e
+	return table.updateFilters()
+}
+
+// checkExpressions verifies that the filter conditions and the filter and
+// derived column expressions only refer to columns in the header.
+func (table *Table) checkExpressions(header []string) (error) {
+	if table.filter != nil {
+		if missing := table.filter.unknownColumns(header, nil); len(missing)>0 {
+			return errors.New("unknown column in filter: " + strings.Join(missing, ", "))
+		}
+	}
+	if table.match_expr != nil {
+		if err := table.match_expr.check(header); err != nil {
+			return err
+		}
 	}
+	for _,d := range(table.derived) {
+		if err := d.expr.check(header); err != nil {
+			return errors.New(d.name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// SetExprCheck makes an expression referring to a column missing from the
+// header an error as soon as the header is read, before any rows are loaded.
+func (table *Table) SetExprCheck(expr_check bool) {
+	table.expr_check = expr_check
+}
 
-	table.match_parser = mexpr.NewInterpreter(ast)
+// SetStrict makes a row with the wrong number of fields an error.
+func (table *Table) SetStrict(strict bool) {
+	table.strict = strict
+}
+
+// SetLenient rejects rows with the wrong number of fields instead of padding
+// or truncating them; rejected rows are counted and written to bad_rows if
+// it is not nil.
+func (table *Table) SetLenient(lenient bool, bad_rows io.Writer) {
+	table.lenient = lenient
+	table.bad_rows = bad_rows
+}
+
+func (table *Table) BadRowCount() (int) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.nbad
 }
 
 func (table *Table) SetHead(head int) {
@@ -98,6 +217,28 @@ func (table *Table) SetDelimiter(d rune) {
 	table.delimiter = d
 }
 
+func (table *Table) SetQuote(q rune) {
+	table.quote = q
+}
+
+func (table *Table) SetEscape(e rune) {
+	table.escape = e
+}
+
+func (table *Table) SetEncoding(encoding string) {
+	if _, ok := NormalizeEncoding(encoding); !ok {
+		log.Fatal("unsupported encoding: ", encoding)
+	}
+	table.encoding = encoding
+}
+
+// SetFixedWidths switches input to fixed-width columns; with nil widths the
+// column boundaries are inferred from the input.
+func (table *Table) SetFixedWidths(widths []int) {
+	table.fixed = true
+	table.widths = widths
+}
+
 func (table *Table) SetOutputDelimiter(d rune) {
 	table.output_delimiter = d
 }
@@ -106,7 +247,29 @@ func (table *Table) SetColumns(c []string) {
 	table.columns = c
 }
 
+func (table *Table) SetNoHeader(no_header bool) {
+	table.no_header = no_header
+}
+
+func (table *Table) SetHeaderNames(names []string) {
+	table.header_names = names
+}
+
+// SetProvenance adds the _file and _line columns recording where each row
+// came from.
+func (table *Table) SetProvenance(with_filename bool, with_lineno bool) {
+	table.with_filename = with_filename
+	table.with_lineno = with_lineno
+}
+
 func (table *Table) calcLimits() {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.resetLimits()
+}
+
+// resetLimits recalculates the column widths; called with the lock held.
+func (table *Table) resetLimits() {
 	ncols := len(table.header)
 	table.limits = make([]int, ncols)
 	for j,cell := range(table.header) {
@@ -120,94 +283,218 @@ func (table *Table) calcLimits() {
 	}
 }
 
-func acceptRow(rec []string, t *Table) (bool) {
-	if len(t.remove) > 0 {
-		line := strings.Join(rec, string(t.delimiter))
-		for _,m := range(t.remove) {
-			if strings.Contains(line, m) {
-				return false
+// prepareHeader appends the derived columns to the header of the current
+// file and checks the expressions and filter conditions against it.
+func (table *Table) prepareHeader(header []string) ([]string, error) {
+	header, err := table.deriveHeader(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if table.expr_check {
+		if err := table.checkExpressions(header); err != nil {
+			return nil, err
+		}
+	} else if table.filter != nil {
+		table.mu.Lock()
+		for _,col := range(table.filter.unknownColumns(header, nil)) {
+			warning := fmt.Sprintf("unknown column %v in filter, searching the whole row instead", col)
+			if findToken(warning, table.warnings)==-1 {
+				table.warnings = append(table.warnings, warning)
 			}
 		}
+		table.mu.Unlock()
 	}
+	return header, nil
+}
 
-	if len(t.match) > 0 {
-		line := strings.Join(rec, string(t.delimiter))
-		for _,m := range(t.match) {
-			if !strings.Contains(line, m) {
-				return false
-			}
+// prepareRecord computes the derived cells of a record of the current file
+// and applies the filters to it. A failing filter expression drops the row;
+// the failures are reported as a warning.
+func (table *Table) prepareRecord(header []string, row []string) ([]string, error) {
+	if len(table.derived)>0 {
+		var err error
+		if row, err = table.deriveRow(header, row); err != nil {
+			return nil, err
 		}
 	}
+	if table.filter == nil {
+		return row, nil
+	}
 
-	if t.match_parser != nil {
-		vars := make(map[string]interface{})
-		for i,h := range(t.header) {
-			v, err := strconv.ParseFloat(rec[i], 32)
-			if err == nil {
-				vars[h] = v
-			} else {
-				vars[h] = rec[i]
-			}
+	accept, err := table.filter.eval(row, header, table)
+	if err != nil {
+		if table.strict {
+			return nil, errors.New(table.fname + ": " + err.Error())
+		}
+
+		table.mu.Lock()
+		if table.expr_errors==0 {
+			table.expr_error = err.Error()
+		}
+		table.expr_errors++
+		table.mu.Unlock()
+		return nil, nil
+	}
+	if !accept {
+		return nil, nil
+	}
+	return row, nil
+}
+
+func (table *Table) newReader(d Dialect, skip int) (RowReader) {
+	var csv *CSVReader
+	var reader RowReader
+	if d.widths!=nil {
+		fixed := NewFixedWidthReader(d.widths)
+		csv = &fixed.CSVReader
+		reader = &fixed
+	} else {
+		r := NewCSVReader()
+		r.SetDelimiter(d.delimiter)
+		r.SetQuote(d.quote)
+		r.SetEscape(d.escape)
+		csv = &r
+		reader = &r
+	}
+
+	csv.SetColumns(table.columns)
+	csv.SetNoHeader(table.no_header)
+	csv.SetHeaderNames(table.header_names)
+	csv.SetLimit(table.limit)
+	csv.SetFileName(d.fname)
+	csv.SetProvenance(table.with_filename, table.with_lineno)
+	csv.SetLineOffset(skip)
+	csv.SetComment(table.comment)
+	csv.SetSkipBlank(table.skip_blank)
+	csv.SetRenames(table.renames)
+	csv.SetNormalizeHeaders(table.normalize)
+	if len(table.derived)>0 || table.filter != nil {
+		csv.SetRecordHook(table)
+		csv.SetRejectRagged(table.strict || table.lenient)
+	}
+	return reader
+}
+
+func (table *Table) consumeRow(reader RowReader, row []string) (bool, error) {
+	if err := reader.Err(); err != nil {
+		return false, err
+	}
+
+	if !table.file_mapped && reader.GetHeader()!=nil {
+		if err := table.mergeHeader(reader.GetHeader()); err != nil {
+			return false, err
 		}
+		table.mu.Lock()
+		table.warnings = append(table.warnings, reader.Warnings()...)
+		table.mu.Unlock()
+	}
 
-		result, err := t.match_parser.Run(vars)
-		if err == nil && result==false {
-			return false
+	if row==nil {
+		return true, nil
+	}
+
+	if bad := reader.Ragged(); bad != nil {
+		if table.strict {
+			return false, bad
+		}
+
+		if table.lenient {
+			table.mu.Lock()
+			table.nbad++
+			table.mu.Unlock()
+			if table.bad_rows != nil {
+				io.WriteString(table.bad_rows, bad.Text + "\n")
+			}
+			return true, nil
 		}
 	}
 
-	return true
+	row = table.mapRow(row)
+	table.mu.Lock()
+	table.inferTypes(row)
+	table.mu.Unlock()
+
+	keep, more := table.window.accept(row)
+	if keep {
+		table.mu.Lock()
+		table.content = append(table.content, row)
+		table.rowAdded(row)
+		table.mu.Unlock()
+	}
+	return more, nil
 }
 
-func (table *Table) processFile(fd *os.File) {
-	skip := table.skip
-	scanner := bufio.NewScanner(fd)
+func (table *Table) processFile(fd io.Reader, fname string) (error) {
+	br, ok := fd.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(fd, sniff_bytes)
+	}
+
+	d := table.sniffInput(br, fname)
+	table.mu.Lock()
+	table.dialects = append(table.dialects, d)
+	table.mu.Unlock()
+	table.file_dialect = d
+
+	skip := table.skip + d.preamble
+	scanner := bufio.NewScanner(br)
 	scanner.Split(bufio.ScanLines)
-	reader := NewCSVReader()
-	reader.SetDelimiter(table.delimiter)
-	reader.SetColumns(table.columns)
-	reader.SetLimit(table.limit)
-	head := table.head
-	tail := table.tail
-
-	for scanner.Scan() {
+	reader := table.newReader(d, skip)
+	table.fname = fname
+	table.file_mapped = false
+	table.file_map = nil
+
+	// without a window covering the whole input, this file gets its own
+	// window so --head/--tail/--rows apply per file
+	standalone := table.window==nil
+	if standalone {
+		table.window = table.newWindow()
+		defer func() {
+			table.window = nil
+		}()
+	}
+
+	more := true
+	var err error
+	for more && scanner.Scan() {
 		if skip>0 {
 			skip--
 			continue
 		}
 
 		row := reader.ParseLine(scanner.Text())
-		if table.header==nil {
-			table.header = reader.GetHeader()
-			table.ncols = len(table.header)
-			continue
-		}
-
-		if !acceptRow(row, table) {
-			continue
+		more, err = table.consumeRow(reader, row)
+		if err != nil {
+			return err
 		}
+	}
 
-		if head!=-1 {
-			if head==0 {
-				break
-			}
+	if err := scanner.Err(); err != nil {
+		return newFileError(fname, err)
+	}
 
-			head--
+	if more && reader.IsPending() {
+		if _, err := table.consumeRow(reader, reader.Flush()); err != nil {
+			return err
 		}
 
-		table.content = append(table.content, row)
+		// neither strict nor lenient keeps the record, but not silently
+		if bad := reader.Ragged(); bad != nil && !table.strict && !table.lenient {
+			table.mu.Lock()
+			table.warnings = append(table.warnings, bad.Error())
+			table.mu.Unlock()
+		}
 	}
 
-	n := len(table.content)
-	if tail!=-1 && n>tail {
-		table.content = table.content[(n-tail):n]
+	if standalone {
+		table.flushWindow()
 	}
-
-	table.nrows = len(table.content)
+	return nil
 }
 
 func guessDelimiter(fname string) (rune) {
-	fname = strings.ToLower(fname)
+	fname = trimCompressionExt(strings.ToLower(fname))
 	if strings.Contains(fname, ".csv") {
 		return ','
 	} else if strings.Contains(fname, ".psv") {
@@ -219,50 +506,163 @@ func guessDelimiter(fname string) (rune) {
 	return ','
 }
 
-func (table *Table) ReadStdin() {
+// openInput returns a buffered UTF-8 reader over the input, decompressing
+// and decoding it on the fly if needed.
+func (table *Table) openInput(fd io.Reader) (*bufio.Reader, error) {
+	r, err := decompress(bufio.NewReaderSize(fd, sniff_bytes))
+	if err != nil {
+		return nil, err
+	}
+
+	r, err = decodeInput(bufio.NewReaderSize(r, sniff_bytes), table.encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReaderSize(r, sniff_bytes), nil
+}
+
+// sniffInput detects the dialect and the preamble of an input, reading only
+// the sample at its start.
+func (table *Table) sniffInput(br *bufio.Reader, fname string) (Dialect) {
+	d := table.detectDialect(br, fname)
+	if table.detect_header && d.widths==nil {
+		d.preamble = table.detectPreamble(sampleLines(br, table.skip, sniff_lines), d)
+	}
+	return d
+}
+
+// SniffStdin detects the dialect of stdin without loading any rows.
+func (table *Table) SniffStdin() (Dialect, error) {
+	br, err := table.openInput(os.Stdin)
+	if err != nil {
+		return Dialect{}, newFileError("stdin", err)
+	}
+	return table.sniffInput(br, "stdin"), nil
+}
+
+// SniffFiles detects the dialect of each input without loading any rows.
+func (table *Table) SniffFiles(files []string) ([]Dialect, error) {
+	files, err := table.expandInputs(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialects []Dialect
+	for _,file := range files {
+		fd, err := os.Open(file)
+		if err != nil {
+			return nil, newFileError(file, err)
+		}
+
+		br, err := table.openInput(fd)
+		if err != nil {
+			fd.Close()
+			return nil, newFileError(file, err)
+		}
+		dialects = append(dialects, table.sniffInput(br, file))
+		fd.Close()
+	}
+	return dialects, nil
+}
+
+// GetDialects returns the dialect detected for each input, in load order.
+func (table *Table) GetDialects() ([]Dialect) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.dialects
+}
+
+func (table *Table) ReadStdin() (error) {
+	table.mu.Lock()
 	table.description = "stdin"
-	if table.delimiter==0 {
-		table.delimiter = ','
+	table.mu.Unlock()
+	br, err := table.openInput(os.Stdin)
+	if err != nil {
+		return newFileError("stdin", err)
+	}
+
+	if err := table.processFile(br, "stdin"); err != nil {
+		return err
 	}
 
-	table.processFile(os.Stdin)
 	table.calcLimits()
+	return nil
 }
 
-func (table *Table) ReadFiles(files []string) {
+func (table *Table) ReadFiles(files []string) (error) {
 	if files==nil || len(files)==0 {
-		log.Fatal("ReadFiles: no files to read")
+		return errors.New("ReadFiles: no files to read")
 	}
 
+	table.mu.Lock()
 	table.header = nil
 	table.description = path.Base(files[0])
+	table.mu.Unlock()
+
+	files, err := table.expandInputs(files)
+	if err != nil {
+		return err
+	}
 
+	var total int64
 	for _,file := range files {
-		fi, err := os.Stat(file)
-		if err != nil && !fi.Mode().IsRegular() {
-			continue
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+	}
+	table.mu.Lock()
+	table.bytes_total = total
+	table.mu.Unlock()
+
+	if !table.per_file {
+		table.window = table.newWindow()
+		defer func() {
+			table.window = nil
+		}()
+	}
+
+	for _,file := range files {
+		if table.window!=nil && table.window.done {
+			break
 		}
 
 		fd, err := os.Open(file)
 		if err != nil {
-			continue
+			return newFileError(file, err)
 		}
 
-		if table.delimiter==0 {
-			table.delimiter = guessDelimiter(file)
+		br, err := table.openInput(&countingReader{fd, table})
+		if err != nil {
+			fd.Close()
+			return newFileError(file, err)
 		}
 
-		table.processFile(fd)
+		err = table.processFile(br, file)
 		fd.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !table.per_file {
+		table.flushWindow()
 	}
+
 	table.calcLimits()
+	return nil
 }
 
 func (table *Table) Search(yorig int, s string) (int) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	if table.ignore_case {
+		s = strings.ToLower(s)
+	}
 	for y:=yorig+1; y<len(table.content); y++ {
 		row := table.content[y]
 		for _,cell := range(row) {
-			if(strings.Contains(cell, s)) {
+			if(containsText(cell, s, table.ignore_case)) {
 				return y
 			}
 		}
@@ -272,10 +672,15 @@ func (table *Table) Search(yorig int, s string) (int) {
 }
 
 func (table *Table) SearchReverse(yorig int, s string) (int) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	if table.ignore_case {
+		s = strings.ToLower(s)
+	}
 	for y:=yorig-1; y>=0; y-- {
 		row := table.content[y]
 		for _,cell := range(row) {
-			if(strings.Contains(cell, s)) {
+			if(containsText(cell, s, table.ignore_case)) {
 				return y
 			}
 		}
@@ -284,7 +689,27 @@ func (table *Table) SearchReverse(yorig int, s string) (int) {
 	return yorig
 }
 
+// rowSource describes where a row came from using the provenance columns
+// if they are loaded; called with the lock held.
+func (table *Table) rowSource(y int) (string) {
+	if y<0 || y>=len(table.content) {
+		return ""
+	}
+
+	row := table.content[y]
+	var source []string
+	if idx := findToken(filename_column, table.header); idx!=-1 {
+		source = append(source, row[idx])
+	}
+	if idx := findToken(lineno_column, table.header); idx!=-1 {
+		source = append(source, row[idx])
+	}
+	return strings.Join(source, ":")
+}
+
 func (table *Table) FindColumn(col string) (int) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
 	for i, s := range(table.header) {
 		if s==col {
 			return i
@@ -295,27 +720,41 @@ func (table *Table) FindColumn(col string) (int) {
 }
 
 func (table *Table) performSort(idx int, rev bool) {
-	sort.Slice(table.content, func(i, j int) bool {
-		s1 := table.content[i][idx]
-		s2 := table.content[j][idx]
-		f1, err1 := strconv.ParseFloat(s1, 8)
-		if err1 == nil {
-			f2, err2 := strconv.ParseFloat(s2, 8)
-			if err2 == nil {
-				if rev {
-					return f1 > f2
-				} else {
-					return f1 < f2
-				}
-			}
-		}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.sort_idx = idx
+	table.sort_rev = rev
+	table.sorted_rows = len(table.content)
+
+	// parse each cell once rather than on every comparison
+	type sortKey struct {
+		value interface{}
+		ok bool
+		text string
+		row []string
+	}
+	t := TypeUnknown
+	if idx>=0 && idx<len(table.header) {
+		t = table.type_overrides[table.header[idx]]
+	}
+	keys := make([]sortKey, len(table.content))
+	for i,row := range(table.content) {
+		v, ok := cellValue(t, row[idx])
+		keys[i] = sortKey{v, ok, row[idx], row}
+	}
 
+	sort.SliceStable(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		c := compareParsed(a.value, a.ok, a.text, b.value, b.ok, b.text)
 		if rev {
-			return s1 > s2
-		} else {
-			return s1 < s2
+			return c > 0
 		}
+		return c < 0
 	})
+
+	for i,k := range(keys) {
+		table.content[i] = k.row
+	}
 }
 
 func (table *Table) SortByIndex(idx int) {
@@ -0,0 +1,334 @@
+package tabulon
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ColumnType is the kind of values found in a column. Types are ordered from
+// the most to the least specific; a column takes the first type that all of
+// its non-empty cells parse as.
+type ColumnType int
+const (
+	TypeUnknown ColumnType = iota
+	TypeBool
+	TypeInt
+	TypeFloat
+	TypeTime
+	TypeString
+)
+
+var type_names = []string{"unknown", "bool", "int", "float", "time", "string"}
+
+// layouts tried, in order, for time values
+var time_layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"02.01.2006",
+	"15:04:05",
+}
+
+func (t ColumnType) String() (string) {
+	return type_names[t]
+}
+
+func ParseColumnType(name string) (ColumnType, bool) {
+	switch strings.ToLower(name) {
+	case "bool", "boolean":
+		return TypeBool, true
+	case "int", "integer":
+		return TypeInt, true
+	case "float", "number":
+		return TypeFloat, true
+	case "time", "date", "datetime":
+		return TypeTime, true
+	case "string", "text":
+		return TypeString, true
+	}
+	return TypeUnknown, false
+}
+
+// ParseTypes parses col=type overrides.
+func ParseTypes(specs []string) (map[string]ColumnType, error) {
+	types := make(map[string]ColumnType)
+	for _,spec := range(specs) {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts)!=2 || len(parts[0])==0 {
+			return nil, errors.New("invalid type, expected column=type: " + spec)
+		}
+		t, ok := ParseColumnType(parts[1])
+		if !ok {
+			return nil, errors.New("unsupported column type: " + parts[1])
+		}
+		types[parts[0]] = t
+	}
+	return types, nil
+}
+
+func parseTime(s string) (time.Time, bool) {
+	for _,layout := range(time_layouts) {
+		if v, err := time.Parse(layout, s); err == nil {
+			return v, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// parseValue converts a cell to an int64, float64, bool or time.Time
+// according to the column type. Empty cells and cells that do not parse as
+// the column type are not converted.
+func parseValue(t ColumnType, s string) (interface{}, bool) {
+	s = strings.TrimSpace(s)
+	if len(s)==0 {
+		return nil, false
+	}
+
+	switch t {
+	case TypeBool:
+		if v, ok := parseBool(s); ok {
+			return v, true
+		}
+	case TypeInt:
+		if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return v, true
+		}
+	case TypeFloat:
+		if v, err := strconv.ParseFloat(s, 64); err == nil {
+			return v, true
+		}
+	case TypeTime:
+		if v, ok := parseTime(s); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// widenType returns the most specific type covering both the column type and
+// the cell.
+func widenType(t ColumnType, s string) (ColumnType) {
+	if t==TypeString || len(strings.TrimSpace(s))==0 {
+		return t
+	}
+
+	if t==TypeUnknown {
+		for _,c := range([]ColumnType{TypeBool, TypeInt, TypeFloat, TypeTime}) {
+			if _, ok := parseValue(c, s); ok {
+				return c
+			}
+		}
+		return TypeString
+	}
+
+	if _, ok := parseValue(t, s); ok {
+		return t
+	}
+	if t==TypeInt {
+		if _, ok := parseValue(TypeFloat, s); ok {
+			return TypeFloat
+		}
+	}
+	return TypeString
+}
+
+// cellValue parses a cell as the type the column is overridden to, or when
+// t is TypeUnknown as the most specific type the cell itself parses as. Sort
+// and expressions both see cells this way, so that they agree on every cell
+// whatever the other rows of the column hold.
+func cellValue(t ColumnType, s string) (interface{}, bool) {
+	if t==TypeUnknown {
+		t = widenType(t, s)
+	}
+	return parseValue(t, s)
+}
+
+// compareValues orders two cells of a column whose type is t, or
+// TypeUnknown if it is not overridden. Cells that parse come first in typed
+// order, the others follow in string order.
+func compareValues(t ColumnType, a string, b string) (int) {
+	va, oka := cellValue(t, a)
+	vb, okb := cellValue(t, b)
+	return compareParsed(va, oka, a, vb, okb, b)
+}
+
+func compareParsed(va interface{}, oka bool, a string, vb interface{}, okb bool, b string) (int) {
+	if oka && okb {
+		if c, ok := compareNumbers(va, vb); ok {
+			return c
+		}
+
+		switch x := va.(type) {
+		case bool:
+			if y, ok := vb.(bool); ok {
+				if x==y {
+					return 0
+				} else if !x {
+					return -1
+				}
+				return 1
+			}
+		case time.Time:
+			if y, ok := vb.(time.Time); ok {
+				if x.Before(y) {
+					return -1
+				} else if x.After(y) {
+					return 1
+				}
+				return 0
+			}
+		}
+
+		// values of different kinds order numbers, then booleans, then times
+		return sign(valueRank(va) - valueRank(vb))
+	}
+
+	if oka != okb {
+		if oka {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func valueRank(v interface{}) (int) {
+	switch v.(type) {
+	case int64, float64:
+		return 0
+	case bool:
+		return 1
+	}
+	return 2
+}
+
+func sign(x int) (int) {
+	if x<0 {
+		return -1
+	} else if x>0 {
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers orders two numbers, exactly if both are integers; ok is
+// false unless both are numbers.
+func compareNumbers(a interface{}, b interface{}) (int, bool) {
+	ia, oka := a.(int64)
+	ib, okb := b.(int64)
+	if oka && okb {
+		if ia<ib {
+			return -1, true
+		} else if ia>ib {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	fa, oka := toFloat(a)
+	fb, okb := toFloat(b)
+	if oka && okb {
+		if fa<fb {
+			return -1, true
+		} else if fa>fb {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case int64:
+		return float64(x), true
+	}
+	return 0, false
+}
+
+// exprValue is the value of a cell as seen by expressions: integers become
+// int64, other numbers float64 and booleans bool, everything else including
+// times stays text. A cell of unknown type is typed by its own content.
+func exprValue(t ColumnType, s string) (interface{}) {
+	v, ok := cellValue(t, s)
+	if _, is_time := v.(time.Time); !ok || is_time {
+		return s
+	}
+	return v
+}
+
+// inferTypes widens the column types with a row about to be added. Called
+// with the lock held.
+func (table *Table) inferTypes(row []string) {
+	if table.types == nil {
+		table.types = make(map[string]ColumnType)
+	}
+
+	for i,h := range(table.header) {
+		if i>=len(row) {
+			break
+		}
+		if t, ok := table.type_overrides[h]; ok {
+			table.types[h] = t
+			continue
+		}
+		table.types[h] = widenType(table.types[h], row[i])
+	}
+}
+
+// ColumnType returns the type of the column at idx, as overridden or as
+// inferred from the rows read so far.
+func (table *Table) ColumnType(idx int) (ColumnType) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	return table.columnType(idx)
+}
+
+func (table *Table) columnType(idx int) (ColumnType) {
+	if idx<0 || idx>=len(table.header) {
+		return TypeUnknown
+	}
+	t := table.types[table.header[idx]]
+	if t==TypeUnknown {
+		return TypeString
+	}
+	return t
+}
+
+// exprTypes returns the types expressions see the columns of a header as:
+// the overridden type, or TypeUnknown to type each cell by its content. The
+// types inferred from the rows so far are not used, as a filter would then
+// depend on the order of the rows.
+func (table *Table) exprTypes(header []string) ([]ColumnType) {
+	types := make([]ColumnType, len(header))
+	for i,h := range(header) {
+		types[i] = table.type_overrides[h]
+	}
+	return types
+}
+
+func (table *Table) SetTypes(specs []string) (error) {
+	types, err := ParseTypes(specs)
+	if err != nil {
+		return err
+	}
+	table.type_overrides = types
+	return nil
+}
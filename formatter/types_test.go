@@ -0,0 +1,157 @@
+package tabulon
+
+import (
+	"testing"
+	"strings"
+)
+
+func Test_widenType(t *testing.T) {
+	cases := []struct {
+		cells []string
+		expected ColumnType
+	}{
+		{[]string{"1", "", "-3"}, TypeInt},
+		{[]string{"1", "2.5"}, TypeFloat},
+		{[]string{"true", "False"}, TypeBool},
+		{[]string{"2024-01-31", "2024-02-01 10:00:00"}, TypeTime},
+		{[]string{"1", "true"}, TypeString},
+		{[]string{"2.5", "n/a"}, TypeString},
+		{[]string{""}, TypeUnknown},
+	}
+
+	for _,c := range(cases) {
+		typ := TypeUnknown
+		for _,cell := range(c.cells) {
+			typ = widenType(typ, cell)
+		}
+		if typ!=c.expected {
+			t.Errorf("%v: got %v, expected %v", c.cells, typ, c.expected)
+		}
+	}
+}
+
+func Test_compareValues(t *testing.T) {
+	if compareValues(TypeInt, "9007199254740993", "9007199254740992")<=0 {
+		t.Error("large integers compared without full precision")
+	}
+	if compareValues(TypeFloat, "10", "9.5")<=0 {
+		t.Error("floats compared as text")
+	}
+	if compareValues(TypeTime, "2024-01-31", "2024-02-01 00:00:00")>=0 {
+		t.Error("times compared as text")
+	}
+	if compareValues(TypeInt, "", "1")<=0 {
+		t.Error("empty cells should sort after values")
+	}
+	if compareValues(TypeString, "10", "9")>=0 {
+		t.Error("strings compared as numbers")
+	}
+}
+
+func loadString(t *testing.T, table *Table, data string) {
+	table.SetDelimiter(',')
+	if err := table.processFile(strings.NewReader(data), "types.csv"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_typedSort(t *testing.T) {
+	table := NewTable()
+	loadString(t, &table, "id,score\n9007199254740993,1\n9007199254740992,2\n10,3\n")
+	if table.ColumnType(0)!=TypeInt {
+		t.Fatalf("id inferred as %v", table.ColumnType(0))
+	}
+
+	table.SortByIndex(0)
+	got := []string{table.content[0][1], table.content[1][1], table.content[2][1]}
+	if strings.Join(got, ",")!="3,2,1" {
+		t.Errorf("incorrect sort order %v", got)
+	}
+
+	table.SortByIndexReverse(0)
+	if table.content[0][1]!="1" {
+		t.Errorf("incorrect reverse sort order %v", table.content)
+	}
+}
+
+func Test_typeOverride(t *testing.T) {
+	table := NewTable()
+	if err := table.SetTypes([]string{"code=string"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.SetMatchExpr(`code == "007"`); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "code\n007\n7\n")
+	if table.ColumnType(0)!=TypeString {
+		t.Errorf("override ignored, got %v", table.ColumnType(0))
+	}
+	if len(table.content)!=1 || table.content[0][0]!="007" {
+		t.Errorf("expression saw converted value: %v", table.content)
+	}
+
+	if err := table.SetTypes([]string{"code=decimal"}); err == nil {
+		t.Error("unsupported type accepted")
+	}
+}
+
+func Test_intEquality(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("n == 2 or n != n"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "n\n1\n2\n3\n")
+	if len(table.content)!=1 || table.content[0][0]!="2" {
+		t.Errorf("integer column not equal to number literal: %v", table.content)
+	}
+}
+
+func Test_largeIntEquality(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("n == 9007199254740992 or n < -9007199254740992"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "n\n9007199254740992\n9007199254740993\n-9007199254740993\n-9007199254740992\n")
+	if len(table.content)!=2 || table.content[0][0]!="9007199254740992" || table.content[1][0]!="-9007199254740993" {
+		t.Errorf("integers above 2^53 compared as floats: %v", table.content)
+	}
+}
+
+func Test_exprTypesIndependentOfOrder(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("n > 5"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "n\n10\nabc\n20\n")
+	if len(table.content)!=2 || table.content[0][0]!="10" || table.content[1][0]!="20" {
+		t.Errorf("rows after a text cell compared differently: %v", table.content)
+	}
+}
+
+func Test_mixedColumnSort(t *testing.T) {
+	table := NewTable()
+	if err := table.SetMatchExpr("v > 9"); err != nil {
+		t.Fatal(err)
+	}
+	loadString(t, &table, "v\n10\n9\nN/A\n100\n-\n2.5\n")
+
+	table.SortByIndex(0)
+	var got []string
+	for _,row := range(table.content) {
+		got = append(got, row[0])
+	}
+	if strings.Join(got, ",")!="10,100" {
+		t.Errorf("incorrect rows %v", got)
+	}
+
+	table = NewTable()
+	loadString(t, &table, "v\n10\n9\nN/A\n100\n-\n2.5\n")
+	table.SortByIndex(0)
+	got = nil
+	for _,row := range(table.content) {
+		got = append(got, row[0])
+	}
+	if strings.Join(got, ",")!="2.5,9,10,100,-,N/A" {
+		t.Errorf("incorrect sort order %v", got)
+	}
+}
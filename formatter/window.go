@@ -0,0 +1,110 @@
+package tabulon
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// rowWindow selects which accepted rows are kept: an optional 1-based
+// inclusive range, then the first head or last tail rows within it. The last
+// tail rows are held in a ring buffer so memory use is bounded by tail.
+type rowWindow struct {
+	start int
+	end int
+	head int
+	tail int
+	seen int
+	ring [][]string
+	next int
+	done bool
+}
+
+func (table *Table) newWindow() (*rowWindow) {
+	return &rowWindow{
+		start: table.row_start,
+		end: table.row_end,
+		head: table.head,
+		tail: table.tail,
+	}
+}
+
+// accept reports whether row should be added to the table now and whether
+// any later row can still be kept.
+func (w *rowWindow) accept(row []string) (bool, bool) {
+	w.seen++
+	if w.seen<w.start {
+		return false, true
+	}
+
+	pos := w.seen - w.start + 1
+	if (w.end!=-1 && w.seen>w.end) || (w.head!=-1 && pos>w.head) {
+		w.done = true
+		return false, false
+	}
+
+	more := (w.end==-1 || w.seen<w.end) && (w.head==-1 || pos<w.head)
+	w.done = !more
+	if w.tail==-1 {
+		return true, more
+	}
+
+	if len(w.ring)<w.tail {
+		w.ring = append(w.ring, row)
+	} else if w.tail>0 {
+		w.ring[w.next] = row
+		w.next = (w.next + 1) % w.tail
+	}
+	return false, more
+}
+
+// rows returns the rows held for tail, oldest first.
+func (w *rowWindow) rows() ([][]string) {
+	out := make([][]string, 0, len(w.ring))
+	out = append(out, w.ring[w.next:]...)
+	return append(out, w.ring[:w.next]...)
+}
+
+// ParseRowRange parses a START:END row range; either side may be omitted.
+// Rows are numbered from 1 and END is inclusive; a missing END is -1.
+func ParseRowRange(s string) (int, int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts)!=2 {
+		return 0, 0, errors.New("row range must be START:END")
+	}
+
+	start, end := 1, -1
+	var err error
+	if len(parts[0])>0 {
+		if start, err = strconv.Atoi(parts[0]); err != nil || start<1 {
+			return 0, 0, errors.New("invalid range start: " + parts[0])
+		}
+	}
+	if len(parts[1])>0 {
+		if end, err = strconv.Atoi(parts[1]); err != nil || end<start {
+			return 0, 0, errors.New("invalid range end: " + parts[1])
+		}
+	}
+	return start, end, nil
+}
+
+func (table *Table) SetRows(start int, end int) {
+	table.row_start = start
+	table.row_end = end
+}
+
+// SetPerFile applies head, tail and the row range to each input file rather
+// than to the input as a whole.
+func (table *Table) SetPerFile(per_file bool) {
+	table.per_file = per_file
+}
+
+// flushWindow adds the rows held for tail to the table.
+func (table *Table) flushWindow() {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _,row := range(table.window.rows()) {
+		table.content = append(table.content, row)
+		table.rowAdded(row)
+	}
+}
@@ -0,0 +1,120 @@
+package tabulon
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func firstColumn(table *Table) ([]string) {
+	var out []string
+	for _,row := range(table.content) {
+		out = append(out, row[0])
+	}
+	return out
+}
+
+func Test_rowWindow(t *testing.T) {
+	input := "n\n1\n2\n3\n4\n5\n6\n"
+	cases := []struct {
+		head, tail, start, end int
+		expected []string
+	}{
+		{-1, -1, 1, -1, []string{"1", "2", "3", "4", "5", "6"}},
+		{2, -1, 1, -1, []string{"1", "2"}},
+		{0, -1, 1, -1, nil},
+		{-1, 2, 1, -1, []string{"5", "6"}},
+		{-1, 0, 1, -1, nil},
+		{-1, 10, 1, -1, []string{"1", "2", "3", "4", "5", "6"}},
+		{-1, -1, 2, 4, []string{"2", "3", "4"}},
+		{2, -1, 3, -1, []string{"3", "4"}},
+		{-1, 2, 1, 4, []string{"3", "4"}},
+	}
+
+	for _,c := range(cases) {
+		table := NewTable()
+		table.SetDelimiter(',')
+		table.SetHead(c.head)
+		table.SetTail(c.tail)
+		table.SetRows(c.start, c.end)
+		table.processFile(strings.NewReader(input), "test")
+		if got := firstColumn(&table); !reflect.DeepEqual(got, c.expected) {
+			t.Error("window ", c, " incorrect: ", got)
+		}
+		if table.nrows != len(c.expected) {
+			t.Error("window ", c, " row count incorrect: ", table.nrows)
+		}
+	}
+}
+
+func Test_rowWindowStops(t *testing.T) {
+	table := NewTable()
+	table.SetDelimiter(',')
+	table.SetRows(1, 2)
+	table.SetLenient(true, nil)
+	// the ragged row after the range must never be read
+	table.processFile(strings.NewReader("n\n1\n2\n3,x\n"), "test")
+	if table.BadRowCount() != 0 {
+		t.Error("reading should stop at the end of the range")
+	}
+}
+
+func Test_rowWindowFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.csv"), filepath.Join(dir, "b.csv")}
+	ioutil.WriteFile(files[0], []byte("n\na1\na2\na3\n"), 0644)
+	ioutil.WriteFile(files[1], []byte("n\nb1\nb2\nb3\n"), 0644)
+
+	table := NewTable()
+	table.SetTail(2)
+	table.ReadFiles(files)
+	if got := firstColumn(&table); !reflect.DeepEqual(got, []string{"b2", "b3"}) {
+		t.Error("global tail incorrect: ", got)
+	}
+
+	table = NewTable()
+	table.SetHead(4)
+	table.ReadFiles(files)
+	if got := firstColumn(&table); !reflect.DeepEqual(got, []string{"a1", "a2", "a3", "b1"}) {
+		t.Error("global head incorrect: ", got)
+	}
+
+	table = NewTable()
+	table.SetPerFile(true)
+	table.SetTail(1)
+	table.ReadFiles(files)
+	if got := firstColumn(&table); !reflect.DeepEqual(got, []string{"a3", "b3"}) {
+		t.Error("per-file tail incorrect: ", got)
+	}
+
+	table = NewTable()
+	table.SetPerFile(true)
+	table.SetRows(2, 2)
+	table.ReadFiles(files)
+	if got := firstColumn(&table); !reflect.DeepEqual(got, []string{"a2", "b2"}) {
+		t.Error("per-file range incorrect: ", got)
+	}
+}
+
+func Test_parseRowRange(t *testing.T) {
+	cases := map[string][2]int{
+		"2:5": {2, 5},
+		":5": {1, 5},
+		"3:": {3, -1},
+		"4:4": {4, 4},
+	}
+	for s,expected := range(cases) {
+		start, end, err := ParseRowRange(s)
+		if err != nil || start != expected[0] || end != expected[1] {
+			t.Error("range ", s, " parsed incorrectly: ", start, end, err)
+		}
+	}
+
+	for _,s := range([]string{"5", "0:3", "5:2", "a:b"}) {
+		if _, _, err := ParseRowRange(s); err == nil {
+			t.Error("range ", s, " should be invalid")
+		}
+	}
+}
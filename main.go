@@ -3,32 +3,72 @@ package main
 import (
 	"os"
 	"log"
+	"errors"
 	"github.com/jessevdk/go-flags"
 	"tabulon/formatter"
 	"fmt"
+	"strings"
+	"strconv"
 )
 
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
 func main() {
 	var opts struct {
 		Stdin bool `short:"S" long:"stdin" description:"read from stdin rather than files"`
 		Limit int `short:"L" long:"limit" description:"limit cell content length to N"`
-		Match []string `short:"m" long:"match" description:"match string (AND)"`
-		Remove []string `short:"r" long:"remove" description:"remove string (AND)"`
-		Expr string `short:"e" long:"expr" description:"match on expression"`
+		Match []string `short:"m" long:"match" description:"match string in the row, col=value or col~regex (AND)"`
+		Remove []string `short:"r" long:"remove" description:"remove rows matching string, col=value or col~regex"`
+		MatchRegex []string `long:"match-regex" description:"keep rows where a cell matches the regular expression (AND)"`
+		RemoveRegex []string `long:"remove-regex" description:"remove rows where a cell matches the regular expression"`
+		Any []string `long:"any" description:"keep rows matching at least one of the conditions separated by ||; repeat for several groups"`
+		ExplainFilter bool `long:"explain-filter" description:"print the combined row filter and exit"`
+		IgnoreCase bool `short:"i" long:"ignore-case" description:"case-insensitive match, remove and search"`
+		Derive []string `long:"derive" description:"add a column computed by an expression, as name=EXPR; it follows the columns selected by -c unless -c places or excludes it"`
+		ExprCheck bool `long:"expr-check" description:"fail before loading rows if an expression or filter refers to a column missing from the header"`
+		Expr string `short:"e" long:"expr" description:"match on expression; functions such as lower, contains, matches, round, isEmpty and year can be called"`
 		Plain bool `short:"p" long:"plain" description:"render to stdout as plaintext"`
 		CSV bool `short:"C" long:"csv" description:"render to stdout as csv"`
 		Skip int `short:"s" long:"skip" description:"skip N lines before load" default:"0"`
+		Comment string `long:"comment" description:"ignore lines starting with this prefix, e.g. '#'" default:""`
+		SkipBlank bool `long:"skip-blank" description:"ignore blank lines"`
+		DetectHeader bool `long:"detect-header" description:"skip preamble lines before the header row"`
 		Delimiter string `short:"d" long:"delimiter" description:"set input delimiter" default:""`
+		Quote string `long:"quote" description:"set input quote character" default:""`
+		Escape string `long:"escape" description:"set input escape character, e.g. backslash" default:""`
+		NoHeader bool `long:"no-header" description:"input has no header row; name columns c1..cN"`
+		Header string `long:"header" description:"comma separated column names for headerless input" default:""`
+		Fixed bool `long:"fixed" description:"read fixed-width columns, inferring widths from the input"`
+		Widths string `long:"widths" description:"comma separated fixed column widths, e.g. 10,5,20" default:""`
+		Encoding string `long:"encoding" description:"input encoding: utf8, latin1, cp1252 or utf16" default:""`
 		OutputDelimiter string `short:"D" long:"output-delimiter" description:"set output delimiter" default:""`
-		Head int `short:"h" long:"head" description:"only consume N first lines of input" default:"-1"`
-		Tail int `short:"t" long:"tail" description:"only consume N last lines of input" default:"-1"`
+		Head int `short:"h" long:"head" description:"only consume N first rows of input" default:"-1"`
+		Tail int `short:"t" long:"tail" description:"only consume N last rows of input" default:"-1"`
+		Rows string `long:"rows" description:"only consume rows START:END of input, numbered from 1 and inclusive" default:""`
+		PerFile bool `long:"per-file" description:"apply --head, --tail and --rows to each file rather than to all input"`
 		List string `short:"l" long:"list-column" description:"output specified column as list" default:""`
 		Unique string `short:"u" long:"unique" description:"output unique values of specified column as list" default:""`
 		TSV bool `long:"tsv" description:"force input delimiter to tab"`
 		PSV bool `long:"psv" description:"force input delimiter to pipe"`
+		Strict bool `long:"strict" description:"fail on the first row with the wrong number of fields or on which the expression fails"`
+		Lenient bool `long:"lenient" description:"reject rows with the wrong number of fields and report a count"`
+		BadRows string `long:"bad-rows" description:"write rejected rows to FILE; implies --lenient" default:""`
+		Merge string `long:"merge" description:"reconcile headers of multiple files by name: union, intersect or strict" default:"union"`
+		WithFilename bool `long:"with-filename" description:"add a _file column with the source file of each row"`
+		WithLineno bool `long:"with-lineno" description:"add a _line column with the source line of each row"`
+		Recursive bool `short:"R" long:"recursive" description:"read directories recursively"`
+		Include []string `long:"include" description:"only read files in directories or globs matching pattern, e.g. '*.csv'"`
+		Exclude []string `long:"exclude" description:"skip files in directories or globs matching pattern"`
+		ShowDialect bool `long:"show-dialect" description:"print the detected input dialect and exit"`
 		SortColumn string `long:"sort-column" description:"sort by column" default:""`
 		Reverse bool `long:"reverse" description:"reverse sort direction"`
-		Columns []string `short:"c" long:"columns" description:"only render specified columns"`
+		Rename []string `long:"rename" description:"rename a column, as old=new"`
+		NormalizeHeaders bool `long:"normalize-headers" description:"convert column names to unique snake_case names"`
+		Type []string `long:"type" description:"set the type of a column instead of inferring it, as col=TYPE with TYPE one of int, float, bool, time or string"`
+		Columns []string `short:"c" long:"columns" description:"only render specified columns: names, indices like 1-3,7, /regex/, !excluded and * for the rest"`
 	}
 
 	args, err := flags.ParseArgs(&opts, os.Args)
@@ -37,7 +77,7 @@ func main() {
 	}
 
 	files:=args[1:]
-	if len(files)==0 && !opts.Stdin {
+	if len(files)==0 && !opts.Stdin && !opts.ExplainFilter {
 		log.Fatal("no input provided; please supply filenames or enable stdin")
 	}
 
@@ -46,16 +86,96 @@ func main() {
 	}
 
 	table := tabulon.NewTable()
-	table.SetMatch(opts.Match)
-	table.SetRemove(opts.Remove)
+	if err := table.SetMatch(opts.Match); err != nil {
+		fail(err)
+	}
+	if err := table.SetRemove(opts.Remove); err != nil {
+		fail(err)
+	}
+	if err := table.SetMatchRegex(opts.MatchRegex); err != nil {
+		fail(err)
+	}
+	if err := table.SetRemoveRegex(opts.RemoveRegex); err != nil {
+		fail(err)
+	}
+	if err := table.SetAny(opts.Any); err != nil {
+		fail(err)
+	}
+	if err := table.SetIgnoreCase(opts.IgnoreCase); err != nil {
+		fail(err)
+	}
 	table.SetSkip(opts.Skip)
+	table.SetComment(opts.Comment)
+	table.SetSkipBlank(opts.SkipBlank)
+	table.SetDetectHeader(opts.DetectHeader)
 	table.SetHead(opts.Head)
 	table.SetTail(opts.Tail)
+	table.SetPerFile(opts.PerFile)
+
+	if len(opts.Rows)>0 {
+		start, end, err := tabulon.ParseRowRange(opts.Rows)
+		if err != nil {
+			log.Fatal(err)
+		}
+		table.SetRows(start, end)
+	}
 	table.SetColumns(opts.Columns)
+	if err := table.SetRenames(opts.Rename); err != nil {
+		fail(err)
+	}
+	table.SetNormalizeHeaders(opts.NormalizeHeaders)
+	if err := table.SetTypes(opts.Type); err != nil {
+		fail(err)
+	}
 	table.SetLimit(opts.Limit)
+	table.SetNoHeader(opts.NoHeader)
+	table.SetEncoding(opts.Encoding)
+	if err := table.SetMerge(opts.Merge); err != nil {
+		fail(err)
+	}
+	table.SetProvenance(opts.WithFilename, opts.WithLineno)
+	table.SetRecursive(opts.Recursive)
+	if err := table.SetInclude(opts.Include); err != nil {
+		fail(err)
+	}
+	if err := table.SetExclude(opts.Exclude); err != nil {
+		fail(err)
+	}
+
+	if len(opts.Header)>0 {
+		table.SetHeaderNames(strings.Split(opts.Header, ","))
+	}
+
+	if opts.Strict && (opts.Lenient || len(opts.BadRows)>0) {
+		log.Fatal("both strict and lenient specified; they are mutually exclusive")
+	}
 
 	if len(opts.Expr)>0 {
-		table.SetMatchExpr(opts.Expr)
+		if err := table.SetMatchExpr(opts.Expr); err != nil {
+			fail(err)
+		}
+	}
+
+	if err := table.SetDerive(opts.Derive); err != nil {
+		fail(err)
+	}
+	table.SetExprCheck(opts.ExprCheck)
+
+	if opts.ExplainFilter {
+		fmt.Print(table.ExplainFilter())
+		os.Exit(0)
+	}
+
+	table.SetStrict(opts.Strict)
+	if len(opts.BadRows)>0 {
+		fd, err := os.Create(opts.BadRows)
+		if err != nil {
+			fail(err)
+		}
+		defer fd.Close()
+		table.SetLenient(true, fd)
+	} else {
+		table.SetLenient(opts.Lenient, nil)
 	}
 
 	if len(opts.Delimiter)>0 {
@@ -66,33 +186,102 @@ func main() {
 		table.SetDelimiter('|')
 	}
 
+	if len(opts.Quote)>0 {
+		table.SetQuote(rune(opts.Quote[0]))
+	}
+
+	if len(opts.Escape)>0 {
+		table.SetEscape(rune(opts.Escape[0]))
+	}
+
+	if len(opts.Widths)>0 {
+		var widths []int
+		for _,w := range(strings.Split(opts.Widths, ",")) {
+			n, err := strconv.Atoi(w)
+			if err != nil || n<=0 {
+				log.Fatal("invalid column width: ", w)
+			}
+			widths = append(widths, n)
+		}
+		table.SetFixedWidths(widths)
+	} else if opts.Fixed {
+		table.SetFixedWidths(nil)
+	}
+
 	if(len(opts.OutputDelimiter)>0) {
 		table.SetOutputDelimiter(rune(opts.OutputDelimiter[0]))
 	}
 
-	if opts.Stdin {
-		table.ReadStdin()
-	} else {
-		table.ReadFiles(files)
+	read := func() (error) {
+		if opts.Stdin {
+			return table.ReadStdin()
+		}
+		return table.ReadFiles(files)
 	}
 
-	if table.IsEmpty() {
-		fmt.Println("no data loaded")
-		os.Exit(1)
+	prepare := func() (error) {
+		if table.IsEmpty() {
+			return errors.New("no data loaded")
+		}
+
+		if len(opts.SortColumn)>0 {
+			idx := table.FindColumn(opts.SortColumn)
+			if idx==-1 {
+				return errors.New("No such column="+opts.SortColumn)
+			}
+
+			if opts.Reverse {
+				table.SortByIndexReverse(idx)
+			} else {
+				table.SortByIndex(idx)
+			}
+		}
+		return nil
 	}
 
-	if len(opts.SortColumn)>0 {
-		idx := table.FindColumn(opts.SortColumn)
-		if idx==-1 {
-			fmt.Println("No such column="+opts.SortColumn)
-			os.Exit(1)
+	if opts.ShowDialect {
+		var dialects []tabulon.Dialect
+		if opts.Stdin {
+			d, err := table.SniffStdin()
+			if err != nil {
+				fail(err)
+			}
+			dialects = append(dialects, d)
+		} else if dialects, err = table.SniffFiles(files); err != nil {
+			fail(err)
 		}
 
-		if opts.Reverse {
-			table.SortByIndexReverse(idx)
-		} else {
-			table.SortByIndex(idx)
+		for _,d := range(dialects) {
+			fmt.Println(d)
 		}
+		os.Exit(0)
+	}
+
+	interactive := len(opts.List)==0 && len(opts.Unique)==0 && !opts.CSV &&
+		!opts.Plain && !opts.Stdin
+	if interactive {
+		table.RenderInteractiveWhileLoading(func() (error) {
+			if err := read(); err != nil {
+				return err
+			}
+			return prepare()
+		})
+	}
+
+	if err := read(); err != nil {
+		fail(err)
+	}
+
+	for _,warning := range(table.Warnings()) {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+
+	if n := table.BadRowCount(); n>0 {
+		fmt.Fprintf(os.Stderr, "%v rows rejected\n", n)
+	}
+
+	if err := prepare(); err != nil {
+		fail(err)
 	}
 
 	if len(opts.List)>0 {
@@ -101,10 +290,8 @@ func main() {
 		table.RenderUnique(opts.Unique)
 	} else if opts.CSV {
 		table.RenderCSV()
-	} else if opts.Plain || opts.Stdin {
-		table.RenderPlaintext()
 	} else {
-		table.RenderInteractive()
+		table.RenderPlaintext()
 	}
 	os.Exit(0)
 }